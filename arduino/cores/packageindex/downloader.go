@@ -0,0 +1,222 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package packageindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// Source is a configured package_index.json URL, together with an
+// optional list of mirrors to try if the primary URL stalls or 404s.
+type Source struct {
+	URL     string
+	Mirrors []string
+}
+
+// FetchResult is the outcome of fetching a single Source.
+type FetchResult struct {
+	Source    Source
+	Index     *Index
+	Err       error
+	FromCache bool
+}
+
+const (
+	fetchTimeout  = 30 * time.Second
+	maxAttempts   = 4
+	initialBackup = 500 * time.Millisecond
+)
+
+// FetchAll downloads every Source concurrently. A stalling or failing URL
+// never blocks the others: each fetch runs in its own goroutine with its
+// own timeout, and the caller gets one FetchResult per Source regardless
+// of how many of them failed. On failure (including exhausting all
+// mirrors), the last-known-good copy under cacheDir is used instead, if
+// any exists.
+func FetchAll(sources []Source, cacheDir, keyringDir *paths.Path) []FetchResult {
+	results := make([]FetchResult, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			results[i] = fetchOne(source, cacheDir, keyringDir)
+		}(i, source)
+	}
+	wg.Wait()
+	return results
+}
+
+// fetchOne tries source.URL, then each of source.Mirrors in order, each
+// with retry and exponential backoff, falling back to the cached copy
+// (if any) when every URL fails.
+func fetchOne(source Source, cacheDir, keyringDir *paths.Path) FetchResult {
+	urls := append([]string{source.URL}, source.Mirrors...)
+
+	var lastErr error
+	for _, url := range urls {
+		data, err := fetchWithRetry(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// A sibling .sig is optional: its absence just means the index
+		// comes back untrusted, not that the fetch failed.
+		sigData, _ := fetchWithRetry(url + ".sig")
+		index, err := parseIndex(data, sigData, keyringDir)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if cacheDir != nil {
+			_ = cachePath(cacheDir, source.URL).WriteFile(data)
+		}
+		return FetchResult{Source: source, Index: index}
+	}
+
+	if cacheDir != nil {
+		if index, err := loadCached(cacheDir, source.URL); err == nil {
+			return FetchResult{Source: source, Index: index, FromCache: true}
+		}
+	}
+	return FetchResult{Source: source, Err: errors.Wrapf(lastErr, "fetching %s", source.URL)}
+}
+
+// fetchWithRetry fetches url, retrying up to maxAttempts times with
+// exponential backoff. Each attempt resumes from where the previous one
+// left off via an HTTP Range request: fetchRange appends whatever bytes it
+// managed to read (even a partial, mid-stream failure) onto buf before
+// returning its error, so the next attempt's offset reflects real
+// progress instead of restarting the transfer from zero. If the server
+// ignores the Range request and replies with a fresh full body instead,
+// fetchRange reports that back via full=true and buf is reset rather than
+// appended to, so that full body isn't tacked onto the partial one already
+// accumulated.
+func fetchWithRetry(url string) ([]byte, error) {
+	var buf []byte
+	backoff := initialBackup
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		read, full, err := fetchRange(url, int64(len(buf)))
+		if full {
+			buf = read
+		} else {
+			buf = append(buf, read...)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return buf, nil
+	}
+	return nil, lastErr
+}
+
+// fetchRange performs a single GET request for url, requesting the bytes
+// starting at offset when offset > 0 so a retry resumes rather than
+// restarting the transfer. The response body is copied incrementally so
+// that a connection drop mid-stream still returns whatever bytes were
+// read before the error, letting the caller retain and resume from them
+// rather than discarding the whole attempt. full reports whether the
+// response is a fresh full body rather than the requested continuation:
+// a server that ignores the Range header replies 200 OK with the entire
+// content from byte 0 instead of 206 Partial Content, and the caller must
+// replace its accumulated buffer with the result instead of appending to
+// it.
+func fetchRange(url string, offset int64) (data []byte, full bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, resp.Body)
+		return buf.Bytes(), resp.StatusCode == http.StatusOK && offset > 0, err
+	default:
+		return nil, false, errors.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// parseIndex parses raw package_index.json bytes by writing them to a
+// temporary file and reusing the existing LoadIndex, so the resume/mirror
+// logic above doesn't need to duplicate index-parsing semantics. When
+// sigData is non-nil, the index's signature is additionally verified
+// against keyringDir and its IsTrusted field set accordingly.
+func parseIndex(data, sigData []byte, keyringDir *paths.Path) (*Index, error) {
+	tmp, err := os.CreateTemp("", "package_index-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	tmp.Close()
+	indexPath := paths.New(tmp.Name())
+
+	if sigData == nil {
+		return LoadIndex(indexPath)
+	}
+
+	sigPath := sigPathFor(indexPath)
+	if err := sigPath.WriteFile(sigData); err != nil {
+		return nil, err
+	}
+	defer os.Remove(sigPath.String())
+	return LoadIndexWithTrust(indexPath, keyringDir)
+}
+
+// cachePath maps a source URL to a stable path under cacheDir.
+func cachePath(cacheDir *paths.Path, url string) *paths.Path {
+	sum := sha256.Sum256([]byte(url))
+	return cacheDir.Join(hex.EncodeToString(sum[:]) + ".json")
+}
+
+// loadCached loads the last-known-good copy of url's index from cacheDir.
+func loadCached(cacheDir *paths.Path, url string) (*Index, error) {
+	return LoadIndex(cachePath(cacheDir, url))
+}
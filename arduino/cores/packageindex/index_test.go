@@ -16,6 +16,10 @@
 package packageindex
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"sort"
 	"testing"
 
 	"github.com/arduino/arduino-cli/arduino/cores"
@@ -25,13 +29,19 @@ import (
 	semver "go.bug.st/relaxed-semver"
 )
 
+// updateGolden regenerates the *.golden.json files under testdata instead
+// of comparing against them. Run as:
+//
+//	go test ./arduino/cores/packageindex/... -run TestIndexFromPlatformReleaseGolden -update
+var updateGolden = flag.Bool("update", false, "update .golden.json files instead of comparing against them")
+
 func TestIndexParsing(t *testing.T) {
 	semver.WarnInvalidVersionWhenParsingRelaxed = true
 
 	list, err := paths.New("testdata").ReadDir()
 	require.NoError(t, err)
 	for _, indexFile := range list {
-		if indexFile.Ext() != ".json" {
+		if indexFile.Ext() != ".json" || bytes.HasSuffix([]byte(indexFile.String()), []byte(".golden.json")) {
 			continue
 		}
 		_, err := LoadIndex(indexFile)
@@ -39,7 +49,76 @@ func TestIndexParsing(t *testing.T) {
 	}
 }
 
-func TestIndexFromPlatformRelease(t *testing.T) {
+// marshalIndented marshals v as two-space indented JSON. Golden comparisons
+// are semantic (see requireMatchesGolden), not byte-for-byte.
+func marshalIndented(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	out, err := json.MarshalIndent(v, "", "  ")
+	require.NoError(t, err)
+	return append(out, '\n')
+}
+
+// requireMatchesGolden compares got against the contents of goldenPath,
+// regenerating the file instead when `-update` is passed. Both sides are
+// canonicalized first (see canonicalizeToolsOrder) since "tools" is built
+// from a Go map and its element order isn't significant.
+func requireMatchesGolden(t *testing.T, goldenPath string, got []byte) {
+	t.Helper()
+	golden := paths.New(goldenPath)
+	if *updateGolden {
+		require.NoError(t, golden.WriteFile(canonicalizeToolsOrder(t, got)))
+		return
+	}
+	want, err := golden.ReadFile()
+	require.NoError(t, err)
+	require.JSONEq(t, string(canonicalizeToolsOrder(t, want)), string(canonicalizeToolsOrder(t, got)))
+}
+
+// canonicalizeToolsOrder sorts every "tools" array found anywhere in data
+// by its marshaled representation. IndexFromPlatformRelease builds "tools"
+// from cores.Package.Tools, a map, so its element order is an
+// implementation detail, not part of the index's semantics.
+func canonicalizeToolsOrder(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var generic interface{}
+	require.NoError(t, json.Unmarshal(data, &generic))
+	sortToolsArrays(generic)
+	out, err := json.Marshal(generic)
+	require.NoError(t, err)
+	return out
+}
+
+func sortToolsArrays(node interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key == "tools" {
+				if arr, ok := child.([]interface{}); ok {
+					sort.Slice(arr, func(i, j int) bool {
+						ai, _ := json.Marshal(arr[i])
+						aj, _ := json.Marshal(arr[j])
+						return string(ai) < string(aj)
+					})
+				}
+			}
+			sortToolsArrays(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			sortToolsArrays(child)
+		}
+	}
+}
+
+// TestIndexFromPlatformReleaseGolden builds a PlatformRelease covering the
+// AVR core's full shape (boards, help, tool dependencies, multi-flavor
+// tools) and diffs IndexFromPlatformRelease's JSON output against
+// testdata/avr.golden.json. Comparing the full marshaled output, rather
+// than hand-picking fields to assert on, means a new field added to
+// either cores.PlatformRelease or indexPlatformRelease shows up as a golden
+// diff instead of silently going unchecked. Regenerate the golden file
+// with `-update` after an intentional schema change.
+func TestIndexFromPlatformReleaseGolden(t *testing.T) {
 	pr := &cores.PlatformRelease{
 		Resource: &resources.DownloadResource{
 			URL:             "http://downloads.arduino.cc/cores/avr-1.6.23.tar.bz2",
@@ -192,163 +271,49 @@ func TestIndexFromPlatformRelease(t *testing.T) {
 		},
 	}
 
-	expectedIndex := Index{
-		IsTrusted: false,
-		Packages: []*indexPackage{{
-			Name:       "arduino",
-			Maintainer: "Arduino",
-			WebsiteURL: "https://arduino.cc/",
-			URL:        "",
-			Email:      "packages@arduino.cc",
-			Help:       indexHelp{Online: "http://www.arduino.cc/en/Reference/HomePage"},
-			Platforms: []*indexPlatformRelease{{
-				Name:            "Arduino AVR Boards",
-				Architecture:    "avr",
-				Version:         semver.MustParse("1.8.3"),
-				Category:        "Arduino",
-				URL:             "http://downloads.arduino.cc/cores/avr-1.6.23.tar.bz2",
-				ArchiveFileName: "avr-1.6.23.tar.bz2",
-				Checksum:        "SHA-256:18618d7f256f26cd77c35f4c888d5d1b2334f07925094fdc99ac3188722284aa",
-				Size:            "5001988",
-				Boards: []indexBoard{
-					{Name: "Arduino Yún"},
-					{Name: "Arduino/Genuino Uno"},
-					{Name: "Arduino Uno WiFi"},
-				},
-				Help: indexHelp{Online: "http://www.arduino.cc/en/Reference/HomePage"},
-				ToolDependencies: []indexToolDependency{
-					{
-						Packager: "arduino",
-						Name:     "avr-gcc",
-						Version:  semver.ParseRelaxed("5.4.0-atmel3.6.1-arduino2"),
-					},
-					{
-						Packager: "arduino",
-						Name:     "avrdude",
-						Version:  semver.ParseRelaxed("6.3.0-arduino14"),
-					},
-					{
-						Packager: "arduino",
-						Name:     "arduinoOTA",
-						Version:  semver.ParseRelaxed("1.2.1"),
-					},
-				},
-			}},
-			Tools: []*indexToolRelease{
-				{
-					Name:    "bossac",
-					Version: semver.ParseRelaxed("1.6.1-arduino"),
-					Systems: []indexToolReleaseFlavour{
-						{
-							OS:              "arm-linux-gnueabihf",
-							URL:             "http://downloads.arduino.cc/bossac-1.6.1-arduino-arm-linux-gnueabihf.tar.bz2",
-							ArchiveFileName: "bossac-1.6.1-arduino-arm-linux-gnueabihf.tar.bz2",
-							Size:            "201341",
-							Checksum:        "SHA-256:8c4e63db982178919c824e7a35580dffc95c3426afa7285de3eb583982d4d391",
-						},
-						{
-							OS:              "i686-mingw32",
-							URL:             "http://downloads.arduino.cc/bossac-1.6.1-arduino-mingw32.tar.gz",
-							ArchiveFileName: "bossac-1.6.1-arduino-mingw32.tar.gz",
-							Size:            "222918",
-							Checksum:        "SHA-256:d59f43e2e83a337d04c4ae88b195a4ee175b8d87fff4c43144d23412a4a9513b",
-						},
-					},
-				},
-				{
-					Name:    "bossac",
-					Version: semver.ParseRelaxed("1.7.0"),
-					Systems: []indexToolReleaseFlavour{
-						{
-							OS:              "i686-mingw32",
-							URL:             "http://downloads.arduino.cc/bossac-1.7.0-arduino-mingw32.tar.bz2",
-							ArchiveFileName: "bossac-1.7.0-arduino-mingw32.tar.bz2",
-							Size:            "243066",
-							Checksum:        "SHA-256:9ef7d11b4fabca0adc17102a0290957d5cc26ce46b422c3a5344722c80acc7b2",
-						},
-						{
-							OS:              "x86_64-apple-darwin",
-							URL:             "http://downloads.arduino.cc/bossac-1.7.0-arduino-x86_64-apple-darwin.tar.bz2",
-							ArchiveFileName: "bossac-1.7.0-arduino-x86_64-apple-darwin.tar.bz2",
-							Size:            "63822",
-							Checksum:        "SHA-256:feac36ab38876c163dcf51bdbcfbed01554eede3d41c59a0e152e170fe5164d2",
-						},
-					},
-				},
-				{
-					Name:    "arm-none-eabi-gcc",
-					Version: semver.ParseRelaxed("4.8.3-2014q1"),
-					Systems: []indexToolReleaseFlavour{
-						{
-							OS:              "arm-linux-gnueabihf",
-							URL:             "http://downloads.arduino.cc/gcc-arm-none-eabi-4.8.3-2014q1-arm.tar.bz2",
-							ArchiveFileName: "gcc-arm-none-eabi-4.8.3-2014q1-arm.tar.bz2",
-							Size:            "44423906",
-							Checksum:        "SHA-256:ebe96b34c4f434667cab0187b881ed585e7c7eb990fe6b69be3c81ec7e11e845",
-						},
-						{
-							OS:              "i686-mingw32",
-							URL:             "http://downloads.arduino.cc/gcc-arm-none-eabi-4.8.3-2014q1-windows.tar.gz",
-							ArchiveFileName: "gcc-arm-none-eabi-4.8.3-2014q1-windows.tar.gz",
-							Size:            "84537449",
-							Checksum:        "SHA-256:fd8c111c861144f932728e00abd3f7d1107e186eb9cd6083a54c7236ea78b7c2",
-						},
-					},
-				},
-				{
-					Name:    "arm-none-eabi-gcc",
-					Version: semver.ParseRelaxed("7-2017q4"),
-					Systems: []indexToolReleaseFlavour{
-						{
-							OS:              "arm-linux-gnueabihf",
-							URL:             "http://downloads.arduino.cc/tools/gcc-arm-none-eabi-4.8.3-2014q1-arm.tar.bz2",
-							ArchiveFileName: "gcc-arm-none-eabi-4.8.3-2014q1-arm.tar.bz2",
-							Size:            "44423906",
-							Checksum:        "SHA-256:ebe96b34c4f434667cab0187b881ed585e7c7eb990fe6b69be3c81ec7e11e845",
-						},
-						{
-							OS:              "aarch64-linux-gnu",
-							URL:             "http://downloads.arduino.cc/tools/gcc-arm-none-eabi-7-2018-q2-update-linuxarm64.tar.bz2",
-							ArchiveFileName: "gcc-arm-none-eabi-7-2018-q2-update-linuxarm64.tar.bz2",
-							Size:            "99558726",
-							Checksum:        "SHA-256:6fb5752fb4d11012bd0a1ceb93a19d0641ff7cf29d289b3e6b86b99768e66f76",
-						},
-					},
-				},
-			},
-		}},
-	}
-
 	in := IndexFromPlatformRelease(pr)
-	require.Equal(t, expectedIndex.IsTrusted, in.IsTrusted)
-	require.Equal(t, len(expectedIndex.Packages), len(in.Packages))
+	requireMatchesGolden(t, "testdata/avr.golden.json", marshalIndented(t, in))
+}
 
-	for i := range expectedIndex.Packages {
-		expectedPackage := expectedIndex.Packages[i]
-		indexPackage := in.Packages[i]
-		require.Equal(t, expectedPackage.Name, indexPackage.Name)
-		require.Equal(t, expectedPackage.Maintainer, indexPackage.Maintainer)
-		require.Equal(t, expectedPackage.WebsiteURL, indexPackage.WebsiteURL)
-		require.Equal(t, expectedPackage.Email, indexPackage.Email)
-		require.Equal(t, expectedPackage.Help.Online, indexPackage.Help.Online)
-		require.Equal(t, len(expectedPackage.Tools), len(indexPackage.Tools))
-		require.ElementsMatch(t, expectedPackage.Tools, indexPackage.Tools)
+// TestIndexRoundtripStrict loads every real-world package_index.json under
+// testdata (Seeed legacy, Spacehuhn, Adafruit, ...) with an unknown-field
+// check enabled, then re-marshals and re-decodes it the same way, asserting
+// the two decodings are semantically identical. This catches schema drift
+// (fields like deprecated/monitor/discovery/packageIndexURL showing up on
+// an index we don't yet model) across the varied shapes real indexes use,
+// instead of only the AVR fixture hardcoded in
+// TestIndexFromPlatformReleaseGolden.
+func TestIndexRoundtripStrict(t *testing.T) {
+	semver.WarnInvalidVersionWhenParsingRelaxed = true
+
+	list, err := paths.New("testdata").ReadDir()
+	require.NoError(t, err)
+	list.FilterSuffix(".json")
 
-		require.Equal(t, len(expectedPackage.Platforms), len(indexPackage.Platforms))
-		for n := range expectedPackage.Platforms {
-			expectedPlatform := expectedPackage.Platforms[n]
-			indexPlatform := indexPackage.Platforms[n]
-			require.Equal(t, expectedPlatform.Name, indexPlatform.Name)
-			require.Equal(t, expectedPlatform.Architecture, indexPlatform.Architecture)
-			require.Equal(t, expectedPlatform.Version.String(), indexPlatform.Version.String())
-			require.Equal(t, expectedPlatform.Category, indexPlatform.Category)
-			require.Equal(t, expectedPlatform.Help.Online, indexPlatform.Help.Online)
-			require.Equal(t, expectedPlatform.URL, indexPlatform.URL)
-			require.Equal(t, expectedPlatform.ArchiveFileName, indexPlatform.ArchiveFileName)
-			require.Equal(t, expectedPlatform.Checksum, indexPlatform.Checksum)
-			require.Equal(t, expectedPlatform.Size, indexPlatform.Size)
-			require.ElementsMatch(t, expectedPlatform.Boards, indexPlatform.Boards)
-			require.ElementsMatch(t, expectedPlatform.ToolDependencies, indexPlatform.ToolDependencies)
+	for _, indexFile := range list {
+		if bytes.HasSuffix([]byte(indexFile.String()), []byte(".golden.json")) {
+			continue
 		}
+		indexFile := indexFile
+		t.Run(indexFile.Base(), func(t *testing.T) {
+			raw, err := indexFile.ReadFile()
+			require.NoError(t, err)
+
+			var strict Index
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			require.NoError(t, dec.Decode(&strict), "index has fields not modeled by packageindex.Index")
+
+			index, err := LoadIndex(indexFile)
+			require.NoError(t, err)
+
+			remarshaled := marshalIndented(t, index)
+			var roundtripped Index
+			dec = json.NewDecoder(bytes.NewReader(remarshaled))
+			dec.DisallowUnknownFields()
+			require.NoError(t, dec.Decode(&roundtripped))
+
+			require.Equal(t, index, &roundtripped, "LoadIndex -> Marshal -> LoadIndex is not semantically idempotent")
+		})
 	}
 }
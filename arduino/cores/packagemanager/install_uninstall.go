@@ -120,7 +120,10 @@ func (pm *PackageManager) UninstallPlatform(platformRelease *cores.PlatformRelea
 	return nil
 }
 
-// InstallTool installs a specific release of a tool.
+// InstallTool installs a specific release of a tool. The archive is
+// extracted at most once into a content-addressed store shared by every
+// package that redistributes the same upstream toolchain; destDir is then
+// materialized as hardlinks into that store entry.
 func (pm *PackageManager) InstallTool(toolRelease *cores.ToolRelease) error {
 	toolResource := toolRelease.GetCompatibleFlavour()
 	if toolResource == nil {
@@ -131,7 +134,7 @@ func (pm *PackageManager) InstallTool(toolRelease *cores.ToolRelease) error {
 		"tools",
 		toolRelease.Tool.Name,
 		toolRelease.Version.String())
-	return toolResource.Install(pm.DownloadDir, pm.TempDir, destDir)
+	return pm.linkToolIntoStore(toolResource, destDir)
 }
 
 // IsManagedToolRelease returns true if the ToolRelease is managed by the PackageManager
@@ -165,6 +168,16 @@ func (pm *PackageManager) UninstallTool(toolRelease *cores.ToolRelease) error {
 		return fmt.Errorf("tool %s is not managed by package manager", toolRelease)
 	}
 
+	if pm.IsToolRequired(toolRelease) {
+		return fmt.Errorf("tool %s is required by an installed platform", toolRelease)
+	}
+
+	if toolResource := toolRelease.GetCompatibleFlavour(); toolResource != nil {
+		if err := pm.unlinkToolFromStore(toolResource, toolRelease.InstallDir); err != nil {
+			return fmt.Errorf("removing tool store entry: %s", err)
+		}
+	}
+
 	if err := toolRelease.InstallDir.RemoveAll(); err != nil {
 		return fmt.Errorf("removing tool files: %s", err)
 	}
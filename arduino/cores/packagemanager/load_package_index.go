@@ -0,0 +1,76 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package packagemanager
+
+import (
+	"github.com/arduino/arduino-cli/arduino/cores/packageindex"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// LoadPackageIndices fetches every configured package index concurrently
+// (see packageindex.FetchAll) and merges every successfully resolved one
+// into pm, caching last-known-good copies under cacheDir and verifying
+// each index's signature against keyringDir (see TrustedKeysDir / the
+// `require-signed-indexes` setting). A stalling or unreachable index no
+// longer blocks the others: every failure is collected and returned
+// together as a single aggregated error, after all the indices that did
+// resolve have already been loaded.
+//
+// MergeIntoPackages is given the index's own IsTrusted bit so it can stamp
+// it onto every cores.Package it merges in: that's the only place the
+// trust decision made here ever reaches the package database, and it's
+// what PostInstallTrustedOnly (see postinstall_policy.go) later reads back
+// off platformRelease.Platform.Package.IsTrusted.
+func (pm *PackageManager) LoadPackageIndices(sources []packageindex.Source, cacheDir, keyringDir *paths.Path) error {
+	results := packageindex.FetchAll(sources, cacheDir, keyringDir)
+	requireSigned := viper.GetBool("package_index.require_signed")
+
+	var failures []error
+	for _, result := range results {
+		if result.Err != nil {
+			failures = append(failures, errors.Wrapf(result.Err, "loading index %s", result.Source.URL))
+			continue
+		}
+		if requireSigned && !result.Index.IsTrusted {
+			failures = append(failures, errors.Errorf("index %s is not signed by a trusted key: refusing to load (require-signed-indexes is set)", result.Source.URL))
+			continue
+		}
+		if err := result.Index.MergeIntoPackages(pm.Packages, result.Index.IsTrusted); err != nil {
+			failures = append(failures, errors.Wrapf(err, "merging index %s", result.Source.URL))
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+	return &MultiIndexError{Errors: failures}
+}
+
+// MultiIndexError aggregates the per-index failures from LoadPackageIndices
+// so that one bad URL is reported without hiding the others.
+type MultiIndexError struct {
+	Errors []error
+}
+
+func (e *MultiIndexError) Error() string {
+	msg := "failed to load one or more package indices:"
+	for _, err := range e.Errors {
+		msg += "\n  - " + err.Error()
+	}
+	return msg
+}
@@ -0,0 +1,219 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package packagemanager
+
+import (
+	"encoding/json"
+
+	"github.com/arduino/arduino-cli/arduino/cores"
+	"github.com/arduino/arduino-cli/arduino/resources"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// LockedResource pins a single platform or tool archive by URL and
+// checksum, the same information a Nix `downloads.nix` entry carries for
+// each fetched derivation.
+type LockedResource struct {
+	Packager        string `json:"packager"`
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	URL             string `json:"url"`
+	ArchiveFileName string `json:"archiveFileName"`
+	Size            int64  `json:"size"`
+	Checksum        string `json:"checksum"`
+}
+
+// Lockfile pins the exact set of platform and tool releases used for a
+// toolchain install, so it can be reproduced byte-for-byte on another
+// machine or offline.
+type Lockfile struct {
+	Platforms []LockedResource `json:"platforms"`
+	Tools     []LockedResource `json:"tools"`
+}
+
+// GenerateLockfile serializes every currently installed PlatformRelease and
+// ToolRelease into a Lockfile.
+func (pm *PackageManager) GenerateLockfile() *Lockfile {
+	lock := &Lockfile{}
+
+	for _, targetPackage := range pm.Packages {
+		for _, platform := range targetPackage.Platforms {
+			release := pm.GetInstalledPlatformRelease(platform)
+			if release == nil || release.Resource == nil {
+				continue
+			}
+			lock.Platforms = append(lock.Platforms, lockedResourceOf(targetPackage.Name, platform.Architecture, release.Version.String(), release.Resource))
+		}
+		for _, tool := range targetPackage.Tools {
+			for _, release := range tool.Releases {
+				if !release.IsInstalled() {
+					continue
+				}
+				flavour := release.GetCompatibleFlavour()
+				if flavour == nil {
+					continue
+				}
+				lock.Tools = append(lock.Tools, lockedResourceOf(targetPackage.Name, tool.Name, release.Version.String(), flavour))
+			}
+		}
+	}
+
+	return lock
+}
+
+func lockedResourceOf(packager, name, version string, resource *resources.DownloadResource) LockedResource {
+	return LockedResource{
+		Packager:        packager,
+		Name:            name,
+		Version:         version,
+		URL:             resource.URL,
+		ArchiveFileName: resource.ArchiveFileName,
+		Size:            resource.Size,
+		Checksum:        resource.Checksum,
+	}
+}
+
+// WriteLockfile writes lock as indented JSON to path.
+func (lock *Lockfile) WriteLockfile(path *paths.Path) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return path.WriteFile(data)
+}
+
+// ReadLockfile reads and parses a Lockfile from path.
+func ReadLockfile(path *paths.Path) (*Lockfile, error) {
+	data, err := path.ReadFile()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	lock := &Lockfile{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return lock, nil
+}
+
+// InstallFromLockfile installs the exact platform and tool versions pinned
+// in the lockfile at path. If mirrorDir is non-nil, pinned archives are
+// looked up there by ArchiveFileName and copied into the download cache
+// before installing, so no network access happens at all when every
+// archive is present locally. Before InstallPlatform/InstallTool is called,
+// each resolved release's checksum and size are compared with what was
+// pinned and installation stops on a mismatch, so a tampered or
+// re-published archive is never installed silently.
+func (pm *PackageManager) InstallFromLockfile(path *paths.Path, mirrorDir *paths.Path) error {
+	lock, err := ReadLockfile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, locked := range lock.Platforms {
+		release, err := pm.resolveLockedPlatform(locked)
+		if err != nil {
+			return err
+		}
+		if err := verifyLockedResource(locked, release.Resource); err != nil {
+			return err
+		}
+		if err := pm.stageFromMirror(mirrorDir, locked); err != nil {
+			return err
+		}
+		if err := pm.InstallPlatform(release); err != nil {
+			return errors.Wrapf(err, "installing locked platform %s:%s", locked.Packager, locked.Name)
+		}
+	}
+
+	for _, locked := range lock.Tools {
+		release, err := pm.resolveLockedTool(locked)
+		if err != nil {
+			return err
+		}
+		flavour := release.GetCompatibleFlavour()
+		if flavour == nil {
+			return errors.Errorf("no compatible flavour found for locked tool %s:%s", locked.Packager, locked.Name)
+		}
+		if err := verifyLockedResource(locked, flavour); err != nil {
+			return err
+		}
+		if err := pm.stageFromMirror(mirrorDir, locked); err != nil {
+			return err
+		}
+		if err := pm.InstallTool(release); err != nil {
+			return errors.Wrapf(err, "installing locked tool %s:%s", locked.Packager, locked.Name)
+		}
+	}
+
+	return nil
+}
+
+func (pm *PackageManager) resolveLockedPlatform(locked LockedResource) (*cores.PlatformRelease, error) {
+	targetPackage, ok := pm.Packages[locked.Packager]
+	if !ok {
+		return nil, errors.Errorf("package %s not found for locked platform %s", locked.Packager, locked.Name)
+	}
+	platform, ok := targetPackage.Platforms[locked.Name]
+	if !ok {
+		return nil, errors.Errorf("platform %s:%s not found", locked.Packager, locked.Name)
+	}
+	release, ok := platform.Releases[locked.Version]
+	if !ok {
+		return nil, errors.Errorf("version %s of platform %s:%s not found", locked.Version, locked.Packager, locked.Name)
+	}
+	return release, nil
+}
+
+func (pm *PackageManager) resolveLockedTool(locked LockedResource) (*cores.ToolRelease, error) {
+	targetPackage, ok := pm.Packages[locked.Packager]
+	if !ok {
+		return nil, errors.Errorf("package %s not found for locked tool %s", locked.Packager, locked.Name)
+	}
+	tool, ok := targetPackage.Tools[locked.Name]
+	if !ok {
+		return nil, errors.Errorf("tool %s:%s not found", locked.Packager, locked.Name)
+	}
+	release, ok := tool.Releases[locked.Version]
+	if !ok {
+		return nil, errors.Errorf("version %s of tool %s:%s not found", locked.Version, locked.Packager, locked.Name)
+	}
+	return release, nil
+}
+
+// verifyLockedResource refuses to proceed if resource's checksum or size
+// diverges from what was pinned in the lockfile.
+func verifyLockedResource(locked LockedResource, resource *resources.DownloadResource) error {
+	if resource.Checksum != locked.Checksum || resource.Size != locked.Size {
+		return errors.Errorf("%s:%s@%s no longer matches the lockfile: expected checksum %s (%d bytes), found %s (%d bytes)",
+			locked.Packager, locked.Name, locked.Version, locked.Checksum, locked.Size, resource.Checksum, resource.Size)
+	}
+	return nil
+}
+
+// stageFromMirror copies locked's pinned archive from mirrorDir into the
+// download cache, if present, so InstallPlatform/InstallTool find it
+// already on disk and skip the network fetch entirely.
+func (pm *PackageManager) stageFromMirror(mirrorDir *paths.Path, locked LockedResource) error {
+	if mirrorDir == nil {
+		return nil
+	}
+	src := mirrorDir.Join(locked.ArchiveFileName)
+	if !src.Exist() {
+		return nil
+	}
+	return src.CopyTo(pm.DownloadDir.Join(locked.ArchiveFileName))
+}
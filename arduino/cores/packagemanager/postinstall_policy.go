@@ -0,0 +1,219 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package packagemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os/exec"
+	"runtime"
+
+	"github.com/arduino/arduino-cli/arduino/cores"
+	"github.com/arduino/arduino-cli/executils"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// PostInstallPolicy gates whether RunPostInstallScriptWithPolicy actually
+// executes a platform's post_install script.
+type PostInstallPolicy string
+
+const (
+	// PostInstallAlways always runs the script, matching the historical
+	// (unsandboxed) RunPostInstallScript behavior.
+	PostInstallAlways PostInstallPolicy = "always"
+	// PostInstallPrompt runs the script only if onConfirm returns true.
+	PostInstallPrompt PostInstallPolicy = "prompt"
+	// PostInstallNever never runs post_install scripts.
+	PostInstallNever PostInstallPolicy = "never"
+	// PostInstallTrustedOnly runs the script only if the platform came
+	// from a signed, trusted index.
+	PostInstallTrustedOnly PostInstallPolicy = "trusted-only"
+)
+
+// PostInstallEvent describes a pending post_install script execution. It's
+// returned by AuditPostInstallScript and passed to the onConfirm callback
+// of RunPostInstallScriptWithPolicy so a gRPC client (e.g. the IDE) can
+// surface a confirmation dialog, with the script's contents and hash, to
+// the user before it runs.
+type PostInstallEvent struct {
+	PlatformRelease *cores.PlatformRelease
+	ScriptPath      string
+	Script          []byte
+	ScriptSHA256    string
+}
+
+// postInstallScriptPath returns the post_install script path for a
+// platform release, or "" if it has none, matching the naming convention
+// already used by RunPostInstallScript.
+func postInstallScriptPath(platformRelease *cores.PlatformRelease) string {
+	name := "post_install.sh"
+	if runtime.GOOS == "windows" {
+		name = "post_install.bat"
+	}
+	script := platformRelease.InstallDir.Join(name)
+	if script.Exist() && script.IsNotDir() {
+		return script.String()
+	}
+	return ""
+}
+
+// AuditPostInstallScript returns the contents and sha256 hash of
+// platformRelease's post_install script without executing it, so a caller
+// can review it (or show it to the user) before deciding whether to run
+// it at all.
+func (pm *PackageManager) AuditPostInstallScript(platformRelease *cores.PlatformRelease) (*PostInstallEvent, error) {
+	if !platformRelease.IsInstalled() {
+		return nil, errors.New("platform not installed")
+	}
+	path := postInstallScriptPath(platformRelease)
+	if path == "" {
+		return nil, nil
+	}
+	data, err := paths.New(path).ReadFile()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sum := sha256.Sum256(data)
+	return &PostInstallEvent{
+		PlatformRelease: platformRelease,
+		ScriptPath:      path,
+		Script:          data,
+		ScriptSHA256:    hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// RunPostInstallScriptWithPolicy runs platformRelease's post_install
+// script, gated by policy:
+//   - always: run unconditionally (the historical behavior)
+//   - never: never run
+//   - trusted-only: run only if the platform's package came from a
+//     signed, trusted index
+//   - prompt: audit the script and run it only if onConfirm(event)
+//     returns true; onConfirm is never called if there's no script
+//
+// On Linux, the script is run sandboxed through bwrap or firejail when
+// either is available on PATH, read-write bind-mounted only to
+// platformRelease.InstallDir and with networking disabled.
+func (pm *PackageManager) RunPostInstallScriptWithPolicy(platformRelease *cores.PlatformRelease, policy PostInstallPolicy, onConfirm func(*PostInstallEvent) bool) error {
+	if !platformRelease.IsInstalled() {
+		return errors.New("platform not installed")
+	}
+
+	switch policy {
+	case PostInstallNever:
+		return nil
+	case PostInstallTrustedOnly:
+		// Set by LoadPackageIndices when merging a signed, trusted
+		// packageindex.Index into pm.Packages.
+		if !platformRelease.Platform.Package.IsTrusted {
+			return nil
+		}
+	case PostInstallPrompt:
+		event, err := pm.AuditPostInstallScript(platformRelease)
+		if err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+		if onConfirm == nil || !onConfirm(event) {
+			return nil
+		}
+	case PostInstallAlways:
+		// fall through to execution below
+	default:
+		return errors.Errorf("unknown post-install policy %q", policy)
+	}
+
+	return pm.runPostInstallScriptSandboxed(platformRelease)
+}
+
+// postInstallPolicyFromConfig reads the `post-install-policy` setting
+// (see --post-install-policy / the post_install.policy config key) and
+// falls back to PostInstallAlways, matching the behavior of the original
+// unconditional RunPostInstallScript, if it's unset or unrecognized.
+func postInstallPolicyFromConfig() PostInstallPolicy {
+	switch PostInstallPolicy(viper.GetString("post_install.policy")) {
+	case PostInstallPrompt:
+		return PostInstallPrompt
+	case PostInstallNever:
+		return PostInstallNever
+	case PostInstallTrustedOnly:
+		return PostInstallTrustedOnly
+	default:
+		return PostInstallAlways
+	}
+}
+
+// RunPostInstallScriptFromConfig runs platformRelease's post_install
+// script gated by the post-install-policy config setting (see
+// postInstallPolicyFromConfig). onConfirm is only invoked when the
+// policy is "prompt"; pass it the callback that surfaces the
+// PostInstallEvent to the user (e.g. a gRPC client confirmation dialog)
+// before the script runs.
+func (pm *PackageManager) RunPostInstallScriptFromConfig(platformRelease *cores.PlatformRelease, onConfirm func(*PostInstallEvent) bool) error {
+	return pm.RunPostInstallScriptWithPolicy(platformRelease, postInstallPolicyFromConfig(), onConfirm)
+}
+
+// runPostInstallScriptSandboxed runs the platform's post_install script,
+// wrapping it with bwrap or firejail on Linux when one is available.
+func (pm *PackageManager) runPostInstallScriptSandboxed(platformRelease *cores.PlatformRelease) error {
+	path := postInstallScriptPath(platformRelease)
+	if path == "" {
+		return nil
+	}
+
+	name, args := path, []string(nil)
+	if runtime.GOOS == "linux" {
+		if sandbox, sandboxArgs := sandboxCommandFor(platformRelease.InstallDir.String(), path); sandbox != "" {
+			name, args = sandbox, sandboxArgs
+		}
+	}
+
+	cmd, err := executils.NewProcess(append([]string{name}, args...)...)
+	if err != nil {
+		return err
+	}
+	cmd.SetDirFromPath(platformRelease.InstallDir)
+	return cmd.Run()
+}
+
+// sandboxCommandFor returns the sandbox binary and arguments to run
+// scriptPath confined to a read-write bind mount of installDir with no
+// network access, preferring bwrap over firejail. It returns "" if
+// neither is installed.
+func sandboxCommandFor(installDir, scriptPath string) (string, []string) {
+	if bwrap, err := exec.LookPath("bwrap"); err == nil {
+		return bwrap, []string{
+			"--ro-bind", "/", "/",
+			"--bind", installDir, installDir,
+			"--unshare-net",
+			"--die-with-parent",
+			scriptPath,
+		}
+	}
+	if firejail, err := exec.LookPath("firejail"); err == nil {
+		return firejail, []string{
+			"--quiet",
+			"--net=none",
+			"--whitelist=" + installDir,
+			scriptPath,
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,201 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package packagemanager
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/arduino/arduino-cli/arduino/resources"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// storeDirName is the name of the content-addressed store directory kept
+// under PackagesDir.
+const storeDirName = ".store"
+
+// refcountFileName tracks, for a single store entry, which
+// packager/tools/<name>/<version> directories currently link to it.
+const refcountFileName = ".refcount.json"
+
+// storeDir returns the root of the content-addressed tool store.
+func (pm *PackageManager) storeDir() *paths.Path {
+	return pm.PackagesDir.Join(storeDirName)
+}
+
+// storeEntryDir returns the store directory a given archive checksum
+// extracts into.
+func (pm *PackageManager) storeEntryDir(resource *resources.DownloadResource) *paths.Path {
+	return pm.storeDir().Join(normalizeChecksum(resource.Checksum))
+}
+
+// normalizeChecksum strips the "SHA-256:" style algorithm prefix recorded
+// in package_index.json, keeping only the hex digest, so it's safe to use
+// as a directory name.
+func normalizeChecksum(checksum string) string {
+	if idx := strings.Index(checksum, ":"); idx != -1 {
+		return checksum[idx+1:]
+	}
+	return checksum
+}
+
+// linkToolIntoStore extracts resource into the content-addressed store
+// exactly once (skipping extraction if another tool release already
+// pulled in the identical archive), then materializes destDir as
+// hardlinks (symlinks on Windows, where cross-filesystem hardlinks are
+// unreliable) into the store entry.
+func (pm *PackageManager) linkToolIntoStore(resource *resources.DownloadResource, destDir *paths.Path) error {
+	entryDir := pm.storeEntryDir(resource)
+	if !entryDir.Exist() {
+		if err := entryDir.MkdirAll(); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := resource.Install(pm.DownloadDir, pm.TempDir, entryDir); err != nil {
+			entryDir.RemoveAll()
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := destDir.Parent().MkdirAll(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := linkTree(entryDir, destDir); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return addStoreRef(entryDir, destDir)
+}
+
+// unlinkToolFromStore drops destDir from the store entry's refcount,
+// garbage-collecting the entry once no installed tool release references
+// it anymore. destDir itself is left in place for the caller to remove.
+func (pm *PackageManager) unlinkToolFromStore(resource *resources.DownloadResource, destDir *paths.Path) error {
+	entryDir := pm.storeEntryDir(resource)
+	remaining, err := removeStoreRef(entryDir, destDir)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return entryDir.RemoveAll()
+	}
+	return nil
+}
+
+// linkTree recreates src's file tree under dst, hardlinking each regular
+// file (symlinking on Windows). src's own refcount bookkeeping file is
+// skipped: it lives inside the content-addressed entry itself, but it
+// isn't part of the extracted tool and must never be linked into a
+// consumer's destDir, which a second release hashing to the same entry
+// would otherwise do since extraction (and the refcount file it leaves
+// behind) is only ever done once.
+func linkTree(src, dst *paths.Path) error {
+	files, err := src.ReadDirRecursive()
+	if err != nil {
+		return err
+	}
+	refcount := refcountFile(src).String()
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if file.String() == refcount {
+			continue
+		}
+		rel, err := file.RelTo(src)
+		if err != nil {
+			return err
+		}
+		target := dst.JoinPath(rel)
+		if err := target.Parent().MkdirAll(); err != nil {
+			return err
+		}
+		if runtime.GOOS == "windows" {
+			if err := os.Symlink(file.String(), target.String()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Link(file.String(), target.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStoreRef records that consumerDir now links to entryDir.
+func addStoreRef(entryDir, consumerDir *paths.Path) error {
+	refs, err := readStoreRefs(entryDir)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if ref == consumerDir.String() {
+			return nil
+		}
+	}
+	refs = append(refs, consumerDir.String())
+	return writeStoreRefs(entryDir, refs)
+}
+
+// removeStoreRef drops consumerDir from entryDir's refcount and returns
+// the number of remaining consumers.
+func removeStoreRef(entryDir, consumerDir *paths.Path) (int, error) {
+	refs, err := readStoreRefs(entryDir)
+	if err != nil {
+		return 0, err
+	}
+	kept := refs[:0]
+	for _, ref := range refs {
+		if ref != consumerDir.String() {
+			kept = append(kept, ref)
+		}
+	}
+	if err := writeStoreRefs(entryDir, kept); err != nil {
+		return 0, err
+	}
+	return len(kept), nil
+}
+
+func refcountFile(entryDir *paths.Path) *paths.Path {
+	return entryDir.Join(refcountFileName)
+}
+
+func readStoreRefs(entryDir *paths.Path) ([]string, error) {
+	file := refcountFile(entryDir)
+	if !file.Exist() {
+		return nil, nil
+	}
+	data, err := file.ReadFile()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var refs []string
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return refs, nil
+}
+
+func writeStoreRefs(entryDir *paths.Path, refs []string) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return refcountFile(entryDir).WriteFile(data)
+}
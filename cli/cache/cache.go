@@ -17,7 +17,11 @@ package cache
 
 import (
 	"os"
+	"time"
 
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/arduino/arduino-cli/legacy/builder/phases"
+	paths "github.com/arduino/go-paths-helper"
 	"github.com/spf13/cobra"
 )
 
@@ -28,10 +32,72 @@ func NewCommand() *cobra.Command {
 		Short: "Arduino cache commands.",
 		Long:  "Arduino cache commands.",
 		Example: "# Clean caches.\n" +
-			" " + os.Args[0] + " cache clean\n\n",
+			" " + os.Args[0] + " cache clean\n\n" +
+			"# List cached core archives.\n" +
+			" " + os.Args[0] + " cache list\n\n" +
+			"# Show what a cached core archive was built from.\n" +
+			" " + os.Args[0] + " cache info <fqbn>\n\n" +
+			"# Evict core archives untouched for more than 30 days.\n" +
+			" " + os.Args[0] + " cache prune --older-than 720h\n\n",
 	}
 
 	cacheCommand.AddCommand(initCleanCommand())
+	cacheCommand.AddCommand(initListCommand())
+	cacheCommand.AddCommand(initInfoCommand())
+	cacheCommand.AddCommand(initPruneCommand())
 
 	return cacheCommand
 }
+
+// coreArchiveCacheDir returns the directory core_*.a archives (and their
+// CoreArchiveMetadata sidecars) are cached under, as populated by
+// phases.CoreBuilder via Context.CoreBuildCachePath.
+func coreArchiveCacheDir() *paths.Path {
+	return paths.New(configuration.Settings.GetString("directories.data")).Join("cache")
+}
+
+// coreArchive is a single cached core_*.a archive and, if available, the
+// CoreArchiveMetadata sidecar phases.compileCore wrote alongside it.
+type coreArchive struct {
+	Path     *paths.Path                 `json:"-"`
+	FileName string                      `json:"file_name"`
+	Size     int64                       `json:"size"`
+	MTime    time.Time                   `json:"mtime"`
+	Metadata *phases.CoreArchiveMetadata `json:"metadata,omitempty"`
+}
+
+// listCoreArchives enumerates every core_*.a archive under
+// coreArchiveCacheDir, along with its decoded metadata sidecar (if any).
+func listCoreArchives() ([]*coreArchive, error) {
+	dir := coreArchiveCacheDir()
+	files, err := dir.ReadDir()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files.FilterOutDirs()
+	files.FilterPrefix("core_")
+	files.FilterSuffix(".a")
+
+	archives := make([]*coreArchive, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f.String())
+		if err != nil {
+			continue
+		}
+		meta, err := phases.ReadCoreArchiveMetadata(f)
+		if err != nil {
+			meta = nil
+		}
+		archives = append(archives, &coreArchive{
+			Path:     f,
+			FileName: f.Base(),
+			Size:     info.Size(),
+			MTime:    info.ModTime(),
+			Metadata: meta,
+		})
+	}
+	return archives, nil
+}
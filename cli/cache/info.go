@@ -0,0 +1,95 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/spf13/cobra"
+)
+
+func initInfoCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "info <fqbn>",
+		Short:   "Show what a cached core archive was built from.",
+		Long:    "Show the platform, version and build properties a cached core_*.a archive for the given FQBN was built from.",
+		Example: "  " + os.Args[0] + " cache info arduino:avr:uno",
+		Args:    cobra.ExactArgs(1),
+		Run:     runInfoCommand,
+	}
+}
+
+// infoResult is the cache info result, for both text and --format json
+// output.
+type infoResult struct {
+	FQBN    string         `json:"fqbn"`
+	Matches []*coreArchive `json:"matches"`
+}
+
+func runInfoCommand(cmd *cobra.Command, args []string) {
+	fqbn := args[0]
+	archives, err := listCoreArchives()
+	if err != nil {
+		feedback.Errorf("Error listing cached core archives: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	// The filename only ever has ':' and '=' replaced with '_' (see
+	// phases.GetCachedCoreArchiveFileName), so a filename-only match has
+	// to compare against the FQBN run through the same replacement.
+	wantUnderscored := strings.NewReplacer(":", "_", "=", "_").Replace(fqbn)
+
+	var matches []*coreArchive
+	for _, a := range archives {
+		decodedFQBN, _ := decodeCoreArchiveFileName(a.FileName)
+		if (a.Metadata != nil && a.Metadata.FQBN == fqbn) || decodedFQBN == wantUnderscored {
+			matches = append(matches, a)
+		}
+	}
+
+	if len(matches) == 0 {
+		feedback.Errorf("No cached core archive found for FQBN %v", fqbn)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	feedback.PrintResult(&infoResult{FQBN: fqbn, Matches: matches})
+}
+
+func (r *infoResult) String() string {
+	var sb strings.Builder
+	for i, a := range r.Matches {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "Archive:     %s\n", a.FileName)
+		fmt.Fprintf(&sb, "Size:        %d\n", a.Size)
+		fmt.Fprintf(&sb, "Modified:    %s\n", a.MTime.Format("2006-01-02 15:04:05"))
+		if a.Metadata == nil {
+			sb.WriteString("Metadata:    not available (archive cached before `cache info` metadata was added)\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "FQBN:        %s\n", a.Metadata.FQBN)
+		fmt.Fprintf(&sb, "Platform:    %s %s\n", a.Metadata.Platform, a.Metadata.PlatformVersion)
+		fmt.Fprintf(&sb, "Core path:   %s\n", a.Metadata.CorePath)
+		fmt.Fprintf(&sb, "Opt. flags:  %s\n", a.Metadata.OptimizationFlags)
+		fmt.Fprintf(&sb, "Built at:    %s\n", a.Metadata.BuiltAt.Format("2006-01-02 15:04:05"))
+	}
+	return sb.String()
+}
@@ -0,0 +1,88 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/arduino/arduino-cli/table"
+	"github.com/spf13/cobra"
+)
+
+func initListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List cached core archives.",
+		Long:    "List every cached core_*.a archive, with its decoded FQBN, optimization-flag hash, size and modification time.",
+		Example: "  " + os.Args[0] + " cache list",
+		Args:    cobra.NoArgs,
+		Run:     runListCommand,
+	}
+}
+
+// listResult is the cache list result, for both text and --format json
+// output.
+type listResult struct {
+	Archives []*coreArchive `json:"archives"`
+}
+
+func runListCommand(cmd *cobra.Command, args []string) {
+	archives, err := listCoreArchives()
+	if err != nil {
+		feedback.Errorf("Error listing cached core archives: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	feedback.PrintResult(&listResult{Archives: archives})
+}
+
+func (r *listResult) String() string {
+	if len(r.Archives) == 0 {
+		return "No cached core archives found."
+	}
+	tab := table.New()
+	tab.SetHeader("FQBN", "Flags hash", "Size", "Modified")
+	for _, a := range r.Archives {
+		fqbn, hash := decodeCoreArchiveFileName(a.FileName)
+		if a.Metadata != nil && a.Metadata.FQBN != "" {
+			// The sidecar, when present, has the real FQBN (with its
+			// original ':' and '=' separators); the filename only ever
+			// has those replaced with '_', which is ambiguous to invert.
+			fqbn = a.Metadata.FQBN
+		}
+		tab.AddRow(fqbn, hash, fmt.Sprintf("%d", a.Size), a.MTime.Format("2006-01-02 15:04:05"))
+	}
+	return tab.Render()
+}
+
+// decodeCoreArchiveFileName splits a core_*.a filename (as produced by
+// phases.GetCachedCoreArchiveFileName) back into its best-effort FQBN and
+// optimization-flag hash: core_<fqbn with : and = replaced by _>_<hash>.a.
+// The FQBN itself may contain underscores from its own replacements, so
+// only the last underscore-separated segment (before the extension) is
+// treated as the hash; prefer the CoreArchiveMetadata sidecar's FQBN when
+// one was written, since the filename can't be inverted exactly.
+func decodeCoreArchiveFileName(fileName string) (fqbn string, hash string) {
+	name := strings.TrimSuffix(strings.TrimPrefix(fileName, "core_"), ".a")
+	idx := strings.LastIndex(name, "_")
+	if idx == -1 {
+		return name, ""
+	}
+	return name[:idx], name[idx+1:]
+}
@@ -0,0 +1,128 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package cache
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/arduino/arduino-cli/legacy/builder/phases"
+	"github.com/spf13/cobra"
+)
+
+var pruneFlags struct {
+	olderThan  time.Duration
+	maxSize    int64
+	keepLatest int
+}
+
+func initPruneCommand() *cobra.Command {
+	pruneCommand := &cobra.Command{
+		Use:   "prune",
+		Short: "Evict stale cached core archives.",
+		Long:  "Evicts cached core_*.a archives that are stale by age, total size, or LRU-by-mtime ordering.",
+		Example: "  " + os.Args[0] + " cache prune --older-than 720h\n" +
+			"  " + os.Args[0] + " cache prune --max-size 536870912\n" +
+			"  " + os.Args[0] + " cache prune --keep-latest 5",
+		Args: cobra.NoArgs,
+		Run:  runPruneCommand,
+	}
+	pruneCommand.Flags().DurationVar(&pruneFlags.olderThan, "older-than", 0, "Evict archives whose mtime is older than this duration (e.g. 720h).")
+	pruneCommand.Flags().Int64Var(&pruneFlags.maxSize, "max-size", 0, "Evict the oldest (by mtime) archives until the total cache size is at most this many bytes.")
+	pruneCommand.Flags().IntVar(&pruneFlags.keepLatest, "keep-latest", 0, "Evict every archive except the N most recently modified.")
+	return pruneCommand
+}
+
+// pruneResult is the cache prune result, for both text and --format json
+// output.
+type pruneResult struct {
+	Evicted []string `json:"evicted"`
+}
+
+func runPruneCommand(cmd *cobra.Command, args []string) {
+	archives, err := listCoreArchives()
+	if err != nil {
+		feedback.Errorf("Error listing cached core archives: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	// Oldest (by mtime) first, so every eviction rule below can just trim
+	// off the front (--older-than, --max-size) or the front past the Nth
+	// entry (--keep-latest).
+	sort.Slice(archives, func(i, j int) bool { return archives[i].MTime.Before(archives[j].MTime) })
+
+	toEvict := map[string]*coreArchive{}
+
+	if pruneFlags.olderThan > 0 {
+		cutoff := time.Now().Add(-pruneFlags.olderThan)
+		for _, a := range archives {
+			if a.MTime.Before(cutoff) {
+				toEvict[a.FileName] = a
+			}
+		}
+	}
+
+	if pruneFlags.keepLatest > 0 && len(archives) > pruneFlags.keepLatest {
+		for _, a := range archives[:len(archives)-pruneFlags.keepLatest] {
+			toEvict[a.FileName] = a
+		}
+	}
+
+	if pruneFlags.maxSize > 0 {
+		var total int64
+		for _, a := range archives {
+			total += a.Size
+		}
+		for _, a := range archives {
+			if total <= pruneFlags.maxSize {
+				break
+			}
+			if _, already := toEvict[a.FileName]; already {
+				total -= a.Size
+				continue
+			}
+			toEvict[a.FileName] = a
+			total -= a.Size
+		}
+	}
+
+	evicted := make([]string, 0, len(toEvict))
+	for name, a := range toEvict {
+		if err := a.Path.Remove(); err != nil {
+			feedback.Errorf("Error removing %v: %v", a.Path, err)
+			os.Exit(errorcodes.ErrGeneric)
+		}
+		phases.CoreArchiveMetadataPath(a.Path).Remove()
+		evicted = append(evicted, name)
+	}
+	sort.Strings(evicted)
+
+	feedback.PrintResult(&pruneResult{Evicted: evicted})
+}
+
+func (r *pruneResult) String() string {
+	if len(r.Evicted) == 0 {
+		return "No archives evicted."
+	}
+	out := "Evicted:\n"
+	for _, name := range r.Evicted {
+		out += "  " + name + "\n"
+	}
+	return out
+}
@@ -19,10 +19,14 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/arduino/arduino-cli/cli/board"
 	"github.com/arduino/arduino-cli/cli/compile"
@@ -35,15 +39,20 @@ import (
 	"github.com/arduino/arduino-cli/cli/globals"
 	"github.com/arduino/arduino-cli/cli/lib"
 	"github.com/arduino/arduino-cli/cli/output"
+	"github.com/arduino/arduino-cli/cli/plugin"
 	"github.com/arduino/arduino-cli/cli/sketch"
 	"github.com/arduino/arduino-cli/cli/upload"
 	"github.com/arduino/arduino-cli/cli/version"
 	"github.com/arduino/arduino-cli/configuration"
+	"github.com/arduino/arduino-cli/logging"
+	"github.com/joho/godotenv"
 	"github.com/mattn/go-colorable"
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
@@ -59,6 +68,8 @@ var (
 	verbose      bool
 	outputFormat string
 	configFile   string
+	envFile      string
+	profileName  string
 )
 
 // Init the cobra root command
@@ -68,6 +79,15 @@ func init() {
 
 // this is here only for testing
 func createCliCommandTree(cmd *cobra.Command) {
+	// every flag bound with bindFlagToViper below also becomes settable
+	// through an ARDUINO_-prefixed environment variable (e.g. --log-level
+	// becomes ARDUINO_LOGGING_LEVEL), with flag > env > config file >
+	// default precedence enforced in preRun by applyViperOverridesToFlags.
+	// This must run before any bindFlagToViper call below.
+	viper.SetEnvPrefix("ARDUINO")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
 	cmd.AddCommand(board.NewCommand())
 	cmd.AddCommand(compile.NewCommand())
 	cmd.AddCommand(config.NewCommand())
@@ -75,21 +95,69 @@ func createCliCommandTree(cmd *cobra.Command) {
 	cmd.AddCommand(daemon.NewCommand())
 	cmd.AddCommand(generatedocs.NewCommand())
 	cmd.AddCommand(lib.NewCommand())
+	cmd.AddCommand(plugin.NewCommand())
 	cmd.AddCommand(sketch.NewCommand())
 	cmd.AddCommand(upload.NewCommand())
 	cmd.AddCommand(version.NewCommand())
 
 	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Print the logs on the standard output.")
+	bindFlagToViper("verbose", cmd.PersistentFlags().Lookup("verbose"))
 	cmd.PersistentFlags().String("log-level", "", "Messages with this level and above will be logged.")
-	viper.BindPFlag("logging.level", cmd.PersistentFlags().Lookup("log-level"))
+	bindFlagToViper("logging.level", cmd.PersistentFlags().Lookup("log-level"))
 	cmd.PersistentFlags().String("log-file", "", "Path to the file where logs will be written.")
-	viper.BindPFlag("logging.file", cmd.PersistentFlags().Lookup("log-file"))
+	bindFlagToViper("logging.file", cmd.PersistentFlags().Lookup("log-file"))
+	cmd.PersistentFlags().Int("log-file-max-size-mb", 100, "Maximum size in megabytes of --log-file before it's rotated.")
+	bindFlagToViper("logging.file.max_size_mb", cmd.PersistentFlags().Lookup("log-file-max-size-mb"))
+	cmd.PersistentFlags().Int("log-file-max-backups", 3, "Number of rotated --log-file backups to keep.")
+	bindFlagToViper("logging.file.max_backups", cmd.PersistentFlags().Lookup("log-file-max-backups"))
+	cmd.PersistentFlags().Int("log-file-max-age-days", 28, "Days to keep rotated --log-file backups around; 0 keeps them forever.")
+	bindFlagToViper("logging.file.max_age_days", cmd.PersistentFlags().Lookup("log-file-max-age-days"))
+	cmd.PersistentFlags().Bool("log-file-compress", false, "Gzip-compress rotated --log-file backups.")
+	bindFlagToViper("logging.file.compress", cmd.PersistentFlags().Lookup("log-file-compress"))
+	cmd.PersistentFlags().String("log-file-permissions", "", "Unix file mode (e.g. \"0600\") to create/chmod --log-file with; leave empty for the default 0666.")
+	bindFlagToViper("logging.file.permissions", cmd.PersistentFlags().Lookup("log-file-permissions"))
 	cmd.PersistentFlags().String("log-format", "", "The output format for the logs, can be [text|json].")
-	viper.BindPFlag("logging.format", cmd.PersistentFlags().Lookup("log-format"))
-	cmd.PersistentFlags().StringVar(&outputFormat, "format", "text", "The output format, can be [text|json].")
+	bindFlagToViper("logging.format", cmd.PersistentFlags().Lookup("log-format"))
+	cmd.PersistentFlags().String("log-schema", logging.SchemaPlain, "The JSON field schema for --log-format=json logs, can be [plain|logstash|ecs]; logstash and ecs rename the default fields to @timestamp/message/level and add service.name/service.version, so ELK/Loki can ingest them without post-processing.")
+	bindFlagToViper("logging.schema", cmd.PersistentFlags().Lookup("log-schema"))
+	cmd.PersistentFlags().StringVar(&outputFormat, "format", "text", "The output format, can be [text|json|jsonmini|yaml|template|jsonpath=<expr>].")
+	bindFlagToViper("format", cmd.PersistentFlags().Lookup("format"))
+	cmd.PersistentFlags().StringVar(&feedback.FormatTemplate, "format-template", "", "Go text/template string used to render the result when --format=template, e.g. '{{.Message}}' (see 'docker inspect --format' for the general idea).")
+	bindFlagToViper("format_template", cmd.PersistentFlags().Lookup("format-template"))
 	cmd.PersistentFlags().StringVar(&configFile, "config-file", "", "The custom config file (if not specified the default will be used).")
+	cmd.PersistentFlags().StringVar(&envFile, "env-file", "", "Load environment variables from this file before the configuration system is initialized (default: a \".env\" file in the working directory, if present). Not itself settable through an environment variable.")
+	cmd.PersistentFlags().StringVar(&profileName, "profile", "", "Configuration profile to use for this invocation, overriding the active one (see 'config profile use').")
+	bindFlagToViper("profile", cmd.PersistentFlags().Lookup("profile"))
 	cmd.PersistentFlags().StringSlice("additional-urls", []string{}, "Additional URLs for the board manager.")
-	viper.BindPFlag("board_manager.additional_urls", cmd.PersistentFlags().Lookup("additional-urls"))
+	bindFlagToViper("board_manager.additional_urls", cmd.PersistentFlags().Lookup("additional-urls"))
+	cmd.PersistentFlags().String("plugins-dir", "", "Directory to discover arduino-cli-<name> plugins in (default ~/.arduino15/cli-plugins).")
+	bindFlagToViper("directories.plugins", cmd.PersistentFlags().Lookup("plugins-dir"))
+	cmd.PersistentFlags().Bool("require-signed-indexes", false, "Refuse to load package indexes whose signature can't be verified against the trusted keyring.")
+	bindFlagToViper("package_index.require_signed", cmd.PersistentFlags().Lookup("require-signed-indexes"))
+	cmd.PersistentFlags().String("post-install-policy", "always", "When to run a platform's post_install script: always, prompt, never or trusted-only.")
+	bindFlagToViper("post_install.policy", cmd.PersistentFlags().Lookup("post-install-policy"))
+	cmd.PersistentFlags().Bool("log-syslog", false, "Send logs to syslog, in addition to --log-file (useful when running `arduino-cli daemon` as a service).")
+	bindFlagToViper("logging.syslog.enabled", cmd.PersistentFlags().Lookup("log-syslog"))
+	cmd.PersistentFlags().String("log-syslog-network", "", "Network to dial the syslog daemon on (\"udp\" or \"tcp\"); leave empty to log to the local syslog instead.")
+	bindFlagToViper("logging.syslog.network", cmd.PersistentFlags().Lookup("log-syslog-network"))
+	cmd.PersistentFlags().String("log-syslog-address", "", "Address of the remote syslog daemon (e.g. \"syslog.example.com:514\"); ignored when --log-syslog-network is empty.")
+	bindFlagToViper("logging.syslog.address", cmd.PersistentFlags().Lookup("log-syslog-address"))
+	cmd.PersistentFlags().String("log-syslog-tag", "arduino-cli", "Tag syslog entries are prefixed with.")
+	bindFlagToViper("logging.syslog.tag", cmd.PersistentFlags().Lookup("log-syslog-tag"))
+	cmd.PersistentFlags().String("log-syslog-priority", "info", "Syslog priority for log entries: emerg, alert, crit, err, warning, notice, info or debug.")
+	bindFlagToViper("logging.syslog.priority", cmd.PersistentFlags().Lookup("log-syslog-priority"))
+	cmd.PersistentFlags().String("log-eventlog", "", "Windows Event Log source name to send logs to (register it first with `arduino-cli config init-eventlog-source`); leave empty to disable. No effect on non-Windows platforms.")
+	bindFlagToViper("logging.eventlog.source", cmd.PersistentFlags().Lookup("log-eventlog"))
+
+	// every subcommand's own flags get the same env-var treatment, keyed by
+	// <command path>.<flag name> unless a call above already tagged them
+	// with a hand-picked key.
+	bindCommandTreeToViper(cmd)
+
+	// Third-party plugins are added last, once every built-in subcommand
+	// is registered, so name-conflict detection has the full built-in set
+	// to check against.
+	plugin.AddExternalCommands(cmd)
 }
 
 // convert the string passed to the `--log-level` option to the corresponding
@@ -109,15 +177,12 @@ func toLogLevel(s string) (t logrus.Level, found bool) {
 }
 
 func parseFormatString(arg string) (feedback.OutputFormat, bool) {
-	f, found := map[string]feedback.OutputFormat{
-		"json": feedback.JSON,
-		"text": feedback.Text,
-	}[arg]
-
-	return f, found
+	return feedback.ParseFormat(arg)
 }
 
 func preRun(cmd *cobra.Command, args []string) {
+	loadEnvFile()
+
 	// before doing anything, decide whether we should log to stdout
 	if verbose {
 		// if we print on stdout, do it in full colors
@@ -137,17 +202,38 @@ func preRun(cmd *cobra.Command, args []string) {
 	}
 	configuration.Init(configPath)
 
+	// a configuration profile, if one is active (or --profile overrides
+	// it), is merged on top of the settings just loaded above
+	if err := config.ApplyActiveProfile(profileName); err != nil {
+		feedback.Errorf("Can't apply configuration profile: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	// env vars and config-file/profile settings now fill in any flag the
+	// user didn't pass explicitly on the command line (flag > env > config
+	// file > default). The --verbose decision above already ran against the
+	// raw flag value, same as the pre-existing --log-file bootstrap
+	// ordering; everything below sees the fully-resolved value.
+	applyViperOverridesToFlags(cmd)
+
 	// normalize the format strings
 	outputFormat = strings.ToLower(outputFormat)
 	// configure the output package
 	output.OutputFormat = outputFormat
 	// configure log format
 	logFormat := strings.ToLower(viper.GetString("logging.format"))
+	logSchema := strings.ToLower(viper.GetString("logging.schema"))
+	if logSchema != logging.SchemaPlain {
+		// logstash/ecs schemas need the fixed @version/service.* fields
+		// stamped onto every entry, on top of whatever the formatter below
+		// renames; the formatter alone can't add fields, only render them.
+		logrus.AddHook(logging.ServiceFieldsHook{ServiceVersion: globals.VersionInfo.VersionString})
+	}
 
 	// should we log to file?
-	logFile := viper.GetString("log.file")
+	logFile := viper.GetString("logging.file")
 	if logFile != "" {
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		writer, err := openRotatingLogFile(logFile)
 		if err != nil {
 			fmt.Printf("Unable to open file for logging: %s", logFile)
 			os.Exit(errorcodes.ErrBadCall)
@@ -155,12 +241,51 @@ func preRun(cmd *cobra.Command, args []string) {
 
 		// we use a hook so we don't get color codes in the log file
 		if logFormat == "json" {
-			logrus.AddHook(lfshook.NewHook(file, &logrus.JSONFormatter{}))
+			logrus.AddHook(lfshook.NewHook(writer, logging.NewFormatter(logSchema)))
+		} else {
+			logrus.AddHook(lfshook.NewHook(writer, &logrus.TextFormatter{}))
+		}
+	}
+
+	// syslog is an additional sink, on top of whichever of stdout/log-file
+	// are already configured above, aimed at `arduino-cli daemon` running
+	// as a long-lived service where an admin wants entries aggregated with
+	// the rest of the host's logs.
+	if viper.GetBool("logging.syslog.enabled") {
+		hook, err := newSyslogHook(
+			viper.GetString("logging.syslog.network"),
+			viper.GetString("logging.syslog.address"),
+			viper.GetString("logging.syslog.priority"),
+			viper.GetString("logging.syslog.tag"),
+		)
+		if err != nil {
+			// A syslog daemon being unreachable (or unsupported, on
+			// Windows) is not fatal: logging is best-effort and shouldn't
+			// take the rest of the command down with it.
+			logrus.Errorf("Could not set up syslog logging: %v", err)
+		} else {
+			logrus.AddHook(hook)
+		}
+	}
+
+	// Windows Event Log is the service-manager counterpart to syslog above,
+	// for arduino-cli daemon running as a Windows service.
+	if source := viper.GetString("logging.eventlog.source"); source != "" {
+		hook, err := newEventLogHook(source)
+		if err != nil {
+			logrus.Errorf("Could not set up Windows Event Log logging: %v", err)
 		} else {
-			logrus.AddHook(lfshook.NewHook(file, &logrus.TextFormatter{}))
+			logrus.AddHook(hook)
 		}
 	}
 
+	// Every entry logged from here on carries which subcommand produced
+	// it, so a syslog/log-file consumer aggregating multiple invocations
+	// can tell them apart; handlers that know more (sketch path, FQBN,
+	// duration) add those fields themselves via logrus.WithFields at the
+	// point they become known (e.g. cli/compile, cli/upload).
+	logrus.AddHook(commandNameHook{command: cmd.CommandPath()})
+
 	// configure logging filter
 	if lvl, found := toLogLevel(viper.GetString("logging.level")); !found {
 		feedback.Errorf("Invalid option for --log-level: %s", viper.GetString("logging.level"))
@@ -171,7 +296,7 @@ func preRun(cmd *cobra.Command, args []string) {
 
 	// set the Logger format
 	if logFormat == "json" {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
+		logrus.SetFormatter(logging.NewFormatter(logSchema))
 	}
 
 	// check the right output format was passed
@@ -188,7 +313,7 @@ func preRun(cmd *cobra.Command, args []string) {
 	logrus.Info("Starting root command preparation (`arduino`)")
 
 	logrus.Info("Formatter set")
-	if outputFormat != "text" {
+	if !feedback.IsTextFormat(format) {
 		cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
 			logrus.Warn("Calling help on JSON format")
 			feedback.Error("Invalid Call : should show Help, but it is available only in TEXT mode.")
@@ -196,3 +321,192 @@ func preRun(cmd *cobra.Command, args []string) {
 		})
 	}
 }
+
+// openRotatingLogFile wraps logFile in a lumberjack.Logger configured from
+// the logging.file.* settings, so a long-running `arduino-cli daemon`
+// doesn't grow it unbounded. A "logging.file.permissions" octal mode, if
+// set, is applied right when the file is created/opened rather than left
+// to lumberjack's own default, and SIGHUP triggers a rotation so external
+// logrotate(8)-style setups that move the file out from under us don't
+// leave us writing into a deleted inode forever.
+func openRotatingLogFile(logFile string) (io.Writer, error) {
+	if err := ensureLogFilePermissions(logFile); err != nil {
+		return nil, err
+	}
+
+	maxSizeMB := viper.GetInt("logging.file.max_size_mb")
+	if maxSizeMB <= 0 {
+		maxSizeMB = 100
+	}
+	rotator := &lumberjack.Logger{
+		Filename:   logFile,
+		MaxSize:    maxSizeMB,
+		MaxBackups: viper.GetInt("logging.file.max_backups"),
+		MaxAge:     viper.GetInt("logging.file.max_age_days"),
+		Compress:   viper.GetBool("logging.file.compress"),
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := rotator.Rotate(); err != nil {
+				logrus.Errorf("Could not rotate %s: %v", logFile, err)
+			}
+		}
+	}()
+
+	return rotator, nil
+}
+
+// ensureLogFilePermissions creates logFile (if missing) with the mode from
+// "logging.file.permissions", or chmods it to that mode if it already
+// exists; a no-op if that setting is empty, leaving the previous 0666
+// default untouched.
+func ensureLogFilePermissions(logFile string) error {
+	permString := viper.GetString("logging.file.permissions")
+	if permString == "" {
+		return nil
+	}
+	perm, err := strconv.ParseUint(permString, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid logging.file.permissions %q: %w", permString, err)
+	}
+
+	if _, err := os.Stat(logFile); os.IsNotExist(err) {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY, os.FileMode(perm))
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return os.Chmod(logFile, os.FileMode(perm))
+}
+
+// loadEnvFile loads environment variables from --env-file (or, if that flag
+// is empty, a ".env" file in the working directory, when present) before the
+// configuration system is initialized, so ARDUINO_-prefixed vars set there
+// are visible to everything below. --env-file is deliberately not itself
+// settable through an environment variable, to avoid a bootstrapping loop.
+func loadEnvFile() {
+	if envFile != "" {
+		if err := godotenv.Load(envFile); err != nil {
+			fmt.Printf("Unable to load --env-file %s: %s\n", envFile, err)
+			os.Exit(errorcodes.ErrBadCall)
+		}
+		return
+	}
+
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Load(".env"); err != nil {
+			logrus.Warnf("Could not load .env: %v", err)
+		}
+	}
+}
+
+// viperKeyAnnotation is the pflag.Flag annotation key bindFlagToViper tags a
+// flag with, recording the viper key it was bound under.
+const viperKeyAnnotation = "arduino-cli/viper-key"
+
+// bindFlagToViper wraps viper.BindPFlag, additionally tagging flag with the
+// viper key it's bound to. flagViperKey uses that tag to recover the key for
+// flags whose command handler reads the Go-bound variable directly rather
+// than calling viper.Get itself.
+func bindFlagToViper(key string, flag *pflag.Flag) {
+	viper.BindPFlag(key, flag)
+	if flag.Annotations == nil {
+		flag.Annotations = map[string][]string{}
+	}
+	flag.Annotations[viperKeyAnnotation] = []string{key}
+}
+
+// flagViperKey returns the viper key flag was bound under. Flags explicitly
+// bound via bindFlagToViper carry it as an annotation; any other flag (a
+// subcommand-local flag that bindCommandTreeToViper bound generically) gets
+// one derived from its command path and name, e.g. "compile" command's
+// "--build-path" becomes "compile.build_path".
+func flagViperKey(cmd *cobra.Command, flag *pflag.Flag) string {
+	if keys, ok := flag.Annotations[viperKeyAnnotation]; ok && len(keys) > 0 {
+		return keys[0]
+	}
+
+	path := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name())
+	path = strings.ReplaceAll(strings.TrimSpace(path), " ", ".")
+	path = strings.ReplaceAll(path, "-", "_")
+	name := strings.ReplaceAll(flag.Name, "-", "_")
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// bindCommandTreeToViper recursively binds every subcommand's own flags to
+// viper (and, through bindFlagToViper, to an ARDUINO_-prefixed env var),
+// keyed by flagViperKey. Root's own persistent flags are bound by hand in
+// createCliCommandTree above and are not touched here.
+func bindCommandTreeToViper(cmd *cobra.Command) {
+	for _, sub := range cmd.Commands() {
+		sub.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+			bindFlagToViper(flagViperKey(sub, flag), flag)
+		})
+		bindCommandTreeToViper(sub)
+	}
+}
+
+// applyViperOverridesToFlags gives every not-explicitly-passed flag in cmd a
+// chance to pick up a value from an ARDUINO_ env var or the config file,
+// ahead of its hardcoded default - flag > env > config file > default. This
+// is needed on top of the viper bindings above because most command handlers
+// in this codebase read a plain Go-bound flag variable rather than calling
+// viper.Get themselves.
+func applyViperOverridesToFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			return
+		}
+		key := flagViperKey(cmd, flag)
+		if !viper.IsSet(key) {
+			return
+		}
+
+		var value string
+		switch raw := viper.Get(key).(type) {
+		case []interface{}:
+			parts := make([]string, len(raw))
+			for i, elem := range raw {
+				parts[i] = fmt.Sprint(elem)
+			}
+			value = strings.Join(parts, ",")
+		case []string:
+			value = strings.Join(raw, ",")
+		default:
+			value = viper.GetString(key)
+		}
+
+		if err := flag.Value.Set(value); err != nil {
+			logrus.Warnf("Could not apply %s to --%s: %v", key, flag.Name, err)
+			return
+		}
+		flag.Changed = true
+	})
+}
+
+// commandNameHook adds a "command" field (the full subcommand path, e.g.
+// "arduino-cli compile") to every log entry, so a sink aggregating logs
+// from many invocations - syslog chief among them - can tell them apart.
+type commandNameHook struct {
+	command string
+}
+
+// Levels implements logrus.Hook.
+func (h commandNameHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (h commandNameHook) Fire(entry *logrus.Entry) error {
+	if _, exists := entry.Data["command"]; !exists {
+		entry.Data["command"] = h.command
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCommand created a new `config` command
+func NewCommand() *cobra.Command {
+	configCommand := &cobra.Command{
+		Use:   "config",
+		Short: "Arduino configuration commands.",
+		Long:  "Arduino configuration commands.",
+		Example: "" +
+			"  " + os.Args[0] + " config set logging.level trace\n" +
+			"  " + os.Args[0] + " config add board_manager.additional_urls https://example.com/package_example_index.json\n" +
+			"  " + os.Args[0] + " config profile create ci",
+	}
+
+	configCommand.AddCommand(initAddCommand())
+	configCommand.AddCommand(initRemoveCommand())
+	configCommand.AddCommand(initSetCommand())
+	configCommand.AddCommand(initUnsetCommand())
+	configCommand.AddCommand(initProfileCommand())
+	configCommand.AddCommand(initInitEventlogSourceCommand())
+
+	return configCommand
+}
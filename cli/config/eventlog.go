@@ -0,0 +1,64 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"os"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/spf13/cobra"
+)
+
+func initInitEventlogSourceCommand() *cobra.Command {
+	var source string
+	var remove bool
+	cmd := &cobra.Command{
+		Use:   "init-eventlog-source",
+		Short: "Registers the Windows Event Log source arduino-cli logs under.",
+		Long: "Registers the Windows Event Log source that --log-eventlog sends entries to.\n" +
+			"Run once per machine, with Administrator privileges, before enabling\n" +
+			"--log-eventlog; pass --remove to deregister it instead. Only available\n" +
+			"on Windows.",
+		Example: "  " + os.Args[0] + " config init-eventlog-source\n" +
+			"  " + os.Args[0] + " config init-eventlog-source --remove",
+		Run: func(cmd *cobra.Command, args []string) {
+			runInitEventlogSourceCommand(source, remove)
+		},
+	}
+	cmd.Flags().StringVar(&source, "source", "arduino-cli", "Event Log source name to register/deregister.")
+	cmd.Flags().BoolVar(&remove, "remove", false, "Deregister the source instead of registering it.")
+	return cmd
+}
+
+func runInitEventlogSourceCommand(source string, remove bool) {
+	var err error
+	if remove {
+		err = deregisterEventLogSource(source)
+	} else {
+		err = registerEventLogSource(source)
+	}
+	if err != nil {
+		feedback.Errorf("Could not update Windows Event Log source %v: %v", source, err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	if remove {
+		feedback.Printf("Event Log source %v deregistered.", source)
+	} else {
+		feedback.Printf("Event Log source %v registered; --log-eventlog %v is now ready to use.", source, source)
+	}
+}
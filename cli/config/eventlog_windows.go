@@ -0,0 +1,33 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+//go:build windows
+
+package config
+
+import "golang.org/x/sys/windows/svc/eventlog"
+
+// registerEventLogSource installs source in the registry as an
+// EventCreate-backed source able to log Error, Warning and Info events -
+// the same three levels eventLogHook (see cli/eventlog_hook_windows.go)
+// maps logrus entries onto.
+func registerEventLogSource(source string) error {
+	return eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+}
+
+// deregisterEventLogSource undoes registerEventLogSource.
+func deregisterEventLogSource(source string) error {
+	return eventlog.Remove(source)
+}
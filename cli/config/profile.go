@@ -0,0 +1,229 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/arduino/arduino-cli/inventory"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// activeProfileKey is the inventory.Store key recording which profile, if
+// any, subsequent CLI invocations should pick up transparently.
+const activeProfileKey = "active_profile"
+
+// profilesDir is the directory configuration profiles are stored under,
+// as <name>.yaml.
+func profilesDir() *paths.Path {
+	return paths.New(configuration.Settings.GetString("directories.data")).Join("profiles")
+}
+
+func profilePath(name string) *paths.Path {
+	return profilesDir().Join(name + ".yaml")
+}
+
+// ApplyActiveProfile merges a configuration profile's settings on top of
+// configuration.Settings: override, if non-empty, is a one-shot --profile
+// name; otherwise the profile recorded as active in inventory.Store (if
+// any) is used. A missing or empty profile name is not an error: it just
+// means no profile applies to this invocation.
+func ApplyActiveProfile(override string) error {
+	name := override
+	if name == "" {
+		name = inventory.Store.GetString(activeProfileKey)
+	}
+	if name == "" {
+		return nil
+	}
+
+	path := profilePath(name)
+	if !path.Exist() {
+		return nil
+	}
+	configuration.Settings.SetConfigFile(path.String())
+	return configuration.Settings.MergeInConfig()
+}
+
+func initProfileCommand() *cobra.Command {
+	profileCommand := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage configuration profiles.",
+		Long:  "Manage named configuration profiles: full snapshots of the settings tree that a single --profile flag (or `config profile use`) can switch between.",
+		Example: "" +
+			"  " + os.Args[0] + " config profile create ci\n" +
+			"  " + os.Args[0] + " config profile use ci\n" +
+			"  " + os.Args[0] + " config profile list\n" +
+			"  " + os.Args[0] + " config profile show\n" +
+			"  " + os.Args[0] + " config profile delete ci",
+	}
+
+	profileCommand.AddCommand(initProfileCreateCommand())
+	profileCommand.AddCommand(initProfileUseCommand())
+	profileCommand.AddCommand(initProfileListCommand())
+	profileCommand.AddCommand(initProfileDeleteCommand())
+	profileCommand.AddCommand(initProfileShowCommand())
+
+	return profileCommand
+}
+
+func initProfileCreateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "create <name>",
+		Short:   "Create a configuration profile from the current settings.",
+		Long:    "Create a configuration profile named <name>, snapshotting the full current settings tree (board_manager URLs, sketchbook path, build cache paths, proxy, unoptimize flags, etc.).",
+		Example: "  " + os.Args[0] + " config profile create ci",
+		Args:    cobra.ExactArgs(1),
+		Run:     runProfileCreateCommand,
+	}
+}
+
+func runProfileCreateCommand(cmd *cobra.Command, args []string) {
+	name := args[0]
+	if err := profilesDir().MkdirAll(); err != nil {
+		feedback.Errorf("Can't create profiles directory: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	snapshot := viper.New()
+	snapshot.SetConfigType("yaml")
+	if err := snapshot.MergeConfigMap(configuration.Settings.AllSettings()); err != nil {
+		feedback.Errorf("Can't snapshot current settings: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	if err := snapshot.WriteConfigAs(profilePath(name).String()); err != nil {
+		feedback.Errorf("Can't write profile %v: %v", name, err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+}
+
+func initProfileUseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "use <name>",
+		Short:   "Switch the active configuration profile.",
+		Long:    "Makes <name> the active configuration profile: subsequent CLI invocations apply its settings unless overridden with --profile.",
+		Example: "  " + os.Args[0] + " config profile use ci",
+		Args:    cobra.ExactArgs(1),
+		Run:     runProfileUseCommand,
+	}
+}
+
+func runProfileUseCommand(cmd *cobra.Command, args []string) {
+	name := args[0]
+	if !profilePath(name).Exist() {
+		feedback.Errorf("Profile %v does not exist", name)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	inventory.Store.Set(activeProfileKey, name)
+	if err := inventory.Store.WriteConfig(); err != nil {
+		feedback.Errorf("Can't record active profile: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+}
+
+func initProfileListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List configuration profiles.",
+		Long:    "List every configuration profile, marking the active one.",
+		Example: "  " + os.Args[0] + " config profile list",
+		Args:    cobra.NoArgs,
+		Run:     runProfileListCommand,
+	}
+}
+
+func runProfileListCommand(cmd *cobra.Command, args []string) {
+	files, err := profilesDir().ReadDir()
+	if err != nil {
+		if os.IsNotExist(err) {
+			feedback.Print("No configuration profiles found.")
+			return
+		}
+		feedback.Errorf("Can't list profiles: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	files.FilterOutDirs()
+	files.FilterSuffix(".yaml")
+
+	active := inventory.Store.GetString(activeProfileKey)
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Base(), ".yaml")
+		marker := "  "
+		if name == active {
+			marker = "* "
+		}
+		feedback.Printf("%s%s", marker, name)
+	}
+}
+
+func initProfileDeleteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "delete <name>",
+		Short:   "Delete a configuration profile.",
+		Long:    "Delete the configuration profile named <name>.",
+		Example: "  " + os.Args[0] + " config profile delete ci",
+		Args:    cobra.ExactArgs(1),
+		Run:     runProfileDeleteCommand,
+	}
+}
+
+func runProfileDeleteCommand(cmd *cobra.Command, args []string) {
+	name := args[0]
+	path := profilePath(name)
+	if !path.Exist() {
+		feedback.Errorf("Profile %v does not exist", name)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	if err := path.Remove(); err != nil {
+		feedback.Errorf("Can't delete profile %v: %v", name, err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	if inventory.Store.GetString(activeProfileKey) == name {
+		inventory.Store.Set(activeProfileKey, "")
+		if err := inventory.Store.WriteConfig(); err != nil {
+			feedback.Errorf("Can't clear active profile: %v", err)
+			os.Exit(errorcodes.ErrGeneric)
+		}
+	}
+}
+
+func initProfileShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "show",
+		Short:   "Show the active configuration profile.",
+		Long:    "Show the name of the active configuration profile, if any.",
+		Example: "  " + os.Args[0] + " config profile show",
+		Args:    cobra.NoArgs,
+		Run:     runProfileShowCommand,
+	}
+}
+
+func runProfileShowCommand(cmd *cobra.Command, args []string) {
+	active := inventory.Store.GetString(activeProfileKey)
+	if active == "" {
+		feedback.Print("No active configuration profile.")
+		return
+	}
+	feedback.Print(active)
+}
@@ -16,9 +16,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/arduino/arduino-cli/cli/errorcodes"
 	"github.com/arduino/arduino-cli/cli/feedback"
@@ -35,6 +38,8 @@ func initSetCommand() *cobra.Command {
 			"  " + os.Args[0] + " config set logging.level trace\n" +
 			"  " + os.Args[0] + " config set logging.file my-log.txt\n" +
 			"  " + os.Args[0] + " config set sketch.always_export_binaries true\n" +
+			"  " + os.Args[0] + " config set network.connection_timeout 30s\n" +
+			"  " + os.Args[0] + " config set build.extra_flags avr=-DFOO sam=-DBAR\n" +
 			"  " + os.Args[0] + " config set board_manager.additional_urls https://example.com/package_example_index.json https://another-url.com/package_another_index.json",
 		Args: cobra.MinimumNArgs(2),
 		Run:  runSetCommand,
@@ -50,24 +55,43 @@ func runSetCommand(cmd *cobra.Command, args []string) {
 		os.Exit(errorcodes.ErrGeneric)
 	}
 
-	if kind != reflect.Slice && len(args) > 2 {
+	if kind != reflect.Slice && kind != reflect.Map && len(args) > 2 {
 		feedback.Errorf("Can't set multiple values in key %v", key)
 		os.Exit(errorcodes.ErrGeneric)
 	}
 
 	var value interface{}
+	var parseErr error
 	switch kind {
 	case reflect.Slice:
 		value = args[1:]
 	case reflect.String:
 		value = args[1]
 	case reflect.Bool:
-		var err error
-		value, err = strconv.ParseBool(args[1])
-		if err != nil {
-			feedback.Errorf("error parsing value: %v", err)
-			os.Exit(errorcodes.ErrGeneric)
+		value, parseErr = strconv.ParseBool(args[1])
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// A duration-valued setting (e.g. a network timeout) also reports
+		// as an integer Kind, since time.Duration is just an int64 under
+		// the hood; the only way to tell them apart is by the concrete
+		// type of the value already configured (or defaulted) for key.
+		if _, isDuration := configuration.Settings.Get(key).(time.Duration); isDuration {
+			value, parseErr = time.ParseDuration(args[1])
+		} else {
+			value, parseErr = strconv.ParseInt(args[1], 10, 64)
 		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, parseErr = strconv.ParseUint(args[1], 10, 64)
+	case reflect.Float32, reflect.Float64:
+		value, parseErr = strconv.ParseFloat(args[1], 64)
+	case reflect.Map:
+		value, parseErr = keyValuePairs(args[1:])
+	default:
+		feedback.Errorf("Can't set key %v: unsupported value type", key)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	if parseErr != nil {
+		feedback.Errorf("error parsing value: %v", parseErr)
+		os.Exit(errorcodes.ErrGeneric)
 	}
 
 	configuration.Settings.Set(key, value)
@@ -77,3 +101,18 @@ func runSetCommand(cmd *cobra.Command, args []string) {
 		os.Exit(errorcodes.ErrGeneric)
 	}
 }
+
+// keyValuePairs parses args as "key=value" pairs, for nested map-valued
+// settings such as build.extra_flags, into a map keyed by the part before
+// the first "=".
+func keyValuePairs(args []string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("'%s' is not a valid key=value pair", arg)
+		}
+		pairs[parts[0]] = parts[1]
+	}
+	return pairs, nil
+}
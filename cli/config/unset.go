@@ -0,0 +1,54 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"os"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/spf13/cobra"
+)
+
+func initUnsetCommand() *cobra.Command {
+	unsetCommand := &cobra.Command{
+		Use:   "unset",
+		Short: "Unsets a setting value.",
+		Long:  "Unsets a setting value, restoring its default (if any).",
+		Example: "" +
+			"  " + os.Args[0] + " config unset logging.level\n" +
+			"  " + os.Args[0] + " config unset board_manager.additional_urls",
+		Args: cobra.ExactArgs(1),
+		Run:  runUnsetCommand,
+	}
+	return unsetCommand
+}
+
+func runUnsetCommand(cmd *cobra.Command, args []string) {
+	key := args[0]
+	if _, err := typeOf(key); err != nil {
+		feedback.Error(err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+
+	configuration.Settings.Set(key, nil)
+
+	if err := configuration.Settings.WriteConfig(); err != nil {
+		feedback.Errorf("Writing config file: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/arduino/arduino-cli/cli/globals"
 	"github.com/arduino/arduino-cli/commands/daemon"
+	"github.com/arduino/arduino-cli/logging"
 	srv_commands "github.com/arduino/arduino-cli/rpc/commands"
 	srv_monitor "github.com/arduino/arduino-cli/rpc/monitor"
 	"github.com/spf13/cobra"
@@ -56,7 +57,13 @@ func NewCommand() *cobra.Command {
 var daemonize bool
 
 func runDaemonCommand(cmd *cobra.Command, args []string) {
-	s := grpc.NewServer()
+	// every RPC gets its own request-scoped logrus.Entry (command,
+	// subcommand, request_id, sketch/fqbn/port when the request carries
+	// them), retrievable downstream with logging.FromContext(ctx).
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(logging.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(logging.StreamServerInterceptor()),
+	)
 
 	// register the commands service
 	headers := http.Header{"User-Agent": []string{
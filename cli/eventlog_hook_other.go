@@ -0,0 +1,30 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+//go:build !windows
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newEventLogHook always fails outside Windows: there is no event log to
+// write to.
+func newEventLogHook(source string) (logrus.Hook, error) {
+	return nil, fmt.Errorf("Windows Event Log logging is only supported on Windows")
+}
@@ -0,0 +1,68 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+//go:build windows
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// genericEventID is the single event ID every entry is logged under; like
+// the old eventlog_formatter from prometheus/common/log, this skips
+// registering a distinct message-table entry per logrus call site.
+const genericEventID = 1
+
+// eventLogHook writes logrus entries to the Windows Event Log through an
+// already-registered source (see `arduino-cli config init-eventlog-source`).
+type eventLogHook struct {
+	log *eventlog.Log
+}
+
+// newEventLogHook opens source (which must already be registered) and
+// wraps it as a logrus hook.
+func newEventLogHook(source string) (logrus.Hook, error) {
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("opening Windows Event Log source %q (run `arduino-cli config init-eventlog-source` first): %w", source, err)
+	}
+	return &eventLogHook{log: log}, nil
+}
+
+// Levels implements logrus.Hook.
+func (h *eventLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, mapping logrus' levels onto the three event
+// types EventCreate-backed sources support.
+func (h *eventLogHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch {
+	case entry.Level <= logrus.ErrorLevel:
+		return h.log.Error(genericEventID, line)
+	case entry.Level == logrus.WarnLevel:
+		return h.log.Warning(genericEventID, line)
+	default:
+		return h.log.Info(genericEventID, line)
+	}
+}
@@ -0,0 +1,168 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package feedback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat identifies one of the registered --format encodings a
+// command's Result can be rendered as.
+type OutputFormat string
+
+// The two formats every arduino-cli build has always supported.
+const (
+	Text OutputFormat = "text"
+	JSON OutputFormat = "json"
+)
+
+// Encoder renders a command's Result struct as the bytes to print for a
+// given --format.
+type Encoder interface {
+	Encode(v interface{}) ([]byte, error)
+}
+
+// EncoderFunc adapts a plain function to the Encoder interface.
+type EncoderFunc func(v interface{}) ([]byte, error)
+
+// Encode implements Encoder.
+func (f EncoderFunc) Encode(v interface{}) ([]byte, error) {
+	return f(v)
+}
+
+// FormatTemplate is the Go text/template string the "template" format
+// executes against a command's Result, set via --format-template (à la
+// `docker inspect --format`).
+var FormatTemplate string
+
+// JSONPathQuery is the expression the "jsonpath" format evaluates against a
+// command's Result, set via --format=jsonpath=<expr>.
+var JSONPathQuery string
+
+var (
+	formats     = map[OutputFormat]Encoder{}
+	textFormats = map[OutputFormat]bool{}
+)
+
+// RegisterFormat adds (or replaces) enc under name in the --format
+// registry. Third-party wrappers embedding cmd/arduino-cli can call this
+// to add a format of their own, as long as it happens before
+// createCliCommandTree runs (e.g. from the wrapper's own init()).
+func RegisterFormat(name string, enc Encoder) {
+	formats[OutputFormat(name)] = enc
+}
+
+// registerTextFormat is like RegisterFormat, but also marks name as a text
+// format for IsTextFormat: formats not marked this way only ever print the
+// command's Result, never cobra's own Long/Example help text, since that
+// text isn't valid in, say, yaml or jsonpath.
+func registerTextFormat(name string, enc Encoder) {
+	RegisterFormat(name, enc)
+	textFormats[OutputFormat(name)] = true
+}
+
+func init() {
+	registerTextFormat(string(Text), EncoderFunc(func(v interface{}) ([]byte, error) {
+		return []byte(fmt.Sprintf("%v", v)), nil
+	}))
+	RegisterFormat(string(JSON), EncoderFunc(func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
+	}))
+	RegisterFormat("jsonmini", EncoderFunc(func(v interface{}) ([]byte, error) {
+		return json.Marshal(v)
+	}))
+	RegisterFormat("yaml", EncoderFunc(func(v interface{}) ([]byte, error) {
+		return yaml.Marshal(v)
+	}))
+	RegisterFormat("template", EncoderFunc(encodeTemplate))
+	RegisterFormat("jsonpath", EncoderFunc(encodeJSONPath))
+}
+
+// encodeTemplate renders v through the Go text/template string in
+// FormatTemplate (set via --format-template).
+func encodeTemplate(v interface{}) ([]byte, error) {
+	tmpl, err := template.New("format").Parse(FormatTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format-template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeJSONPath renders v through the JSONPath expression in
+// JSONPathQuery (set via --format=jsonpath=<expr>). v is round-tripped
+// through JSON first, since jsonpath.Execute walks a decoded
+// map[string]interface{} tree rather than arbitrary Result structs.
+func encodeJSONPath(v interface{}) ([]byte, error) {
+	jp := jsonpath.New("format")
+	if err := jp.Parse(JSONPathQuery); err != nil {
+		return nil, fmt.Errorf("invalid --format=jsonpath=%s: %w", JSONPathQuery, err)
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jp.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseFormat resolves arg into a registered OutputFormat. The
+// "jsonpath=<expr>" scripting shortcut is handled ahead of the plain
+// registry lookup: it captures expr into JSONPathQuery and resolves to the
+// "jsonpath" format registered above.
+func ParseFormat(arg string) (OutputFormat, bool) {
+	if strings.HasPrefix(arg, "jsonpath=") {
+		JSONPathQuery = strings.TrimPrefix(arg, "jsonpath=")
+		arg = "jsonpath"
+	}
+	_, found := formats[OutputFormat(arg)]
+	return OutputFormat(arg), found
+}
+
+// IsTextFormat reports whether format was registered with
+// registerTextFormat, i.e. whether printing a command's ordinary cobra
+// help text (rather than just its Result) is meaningful in it.
+func IsTextFormat(format OutputFormat) bool {
+	return textFormats[format]
+}
+
+// Encode renders v with format's registered Encoder.
+func Encode(format OutputFormat, v interface{}) ([]byte, error) {
+	enc, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	return enc.Encode(v)
+}
@@ -0,0 +1,82 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/spf13/cobra"
+)
+
+// daemonSocket is the address of the daemon started by `arduino-cli
+// daemon`, forwarded to plugins so they can reuse the running instance's
+// package manager instead of spawning their own.
+const daemonSocket = ":50051"
+
+// AddExternalCommands discovers installed plugins and registers one
+// cobra.Command per plugin under root, skipping (and warning about) any
+// plugin whose name would shadow a built-in subcommand.
+func AddExternalCommands(root *cobra.Command) {
+	plugins, err := Discover()
+	if err != nil {
+		return
+	}
+
+	builtins := map[string]bool{}
+	for _, cmd := range root.Commands() {
+		builtins[cmd.Name()] = true
+	}
+
+	for _, p := range plugins {
+		if builtins[p.Name] {
+			feedback.Errorf("Plugin %s ignored: conflicts with a built-in command", p.Name)
+			continue
+		}
+		root.AddCommand(newPluginCommand(p))
+	}
+}
+
+// newPluginCommand wraps a discovered plugin executable in a cobra.Command
+// that forwards argv verbatim and exposes the daemon socket and active
+// config file through the environment.
+func newPluginCommand(p *Plugin) *cobra.Command {
+	return &cobra.Command{
+		Use:                p.Name,
+		Short:              p.Metadata.ShortDescription,
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			run := exec.Command(p.Path.String(), args...)
+			run.Stdin = os.Stdin
+			run.Stdout = os.Stdout
+			run.Stderr = os.Stderr
+			run.Env = append(os.Environ(),
+				"ARDUINO_CLI_PLUGIN_SOCKET="+daemonSocket,
+				"ARDUINO_CLI_CONFIG="+configuration.Settings.ConfigFileUsed(),
+			)
+			if err := run.Run(); err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					os.Exit(exitErr.ExitCode())
+				}
+				feedback.Errorf("Error running plugin %s: %v", p.Name, err)
+				os.Exit(errorcodes.ErrGeneric)
+			}
+		},
+	}
+}
@@ -0,0 +1,182 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package plugin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func initInstallCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "install <url>",
+		Short: "Install a plugin from a signed archive URL.",
+		Long: "Download a plugin archive from <url>, verify its detached signature " +
+			"(<url>.sig) against the configured trust root, and unpack it into the " +
+			"plugins directory.",
+		Args: cobra.ExactArgs(1),
+		Run:  runInstallCommand,
+	}
+}
+
+func runInstallCommand(cmd *cobra.Command, args []string) {
+	if err := install(args[0]); err != nil {
+		feedback.Errorf("Error installing plugin: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+}
+
+// install downloads the archive at url, verifies it against the
+// configured trust root and installs the contained plugin executable into
+// Dir(). Archives are expected to be a single plugin binary and a detached
+// signature served at url+".sig".
+func install(url string) error {
+	archive, err := download(url)
+	if err != nil {
+		return errors.Wrap(err, "downloading plugin archive")
+	}
+	signature, err := download(url + ".sig")
+	if err != nil {
+		return errors.Wrap(err, "downloading plugin signature")
+	}
+
+	trustRoot := viper.GetString("plugins.trusted_root")
+	if trustRoot == "" {
+		return errors.New("no plugins.trusted_root configured: refusing to install unverified plugins")
+	}
+	pub, err := loadTrustRoot(trustRoot)
+	if err != nil {
+		return errors.Wrap(err, "loading trust root")
+	}
+	if err := verify(pub, archive, signature); err != nil {
+		return errors.Wrap(err, "signature verification failed")
+	}
+
+	binary, err := unpackPlugin(archive)
+	if err != nil {
+		return errors.Wrap(err, "unpacking plugin archive")
+	}
+
+	dir := Dir()
+	if err := dir.MkdirAll(); err != nil {
+		return err
+	}
+	dest := dir.Join(pluginExecutableName(url))
+	if err := dest.WriteFile(binary); err != nil {
+		return err
+	}
+	return os.Chmod(dest.String(), 0755)
+}
+
+// unpackPlugin extracts the plugin executable from a gzipped tar archive,
+// taken to be the first regular file it contains. Every later entry (docs,
+// a LICENSE) is ignored rather than installed alongside it; a regular file
+// placed ahead of the executable is returned in its place.
+func unpackPlugin(archive []byte) ([]byte, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("archive does not contain a plugin executable")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		return io.ReadAll(tr)
+	}
+}
+
+// pluginExecutableName derives the "arduino-cli-<name>" executable name
+// Discover() looks for from the archive's URL, stripping the ".tar.gz"/
+// ".tgz" extension and enforcing pluginPrefix so a plugin served from a
+// differently-named archive still installs under the name Discover()
+// expects.
+func pluginExecutableName(url string) string {
+	name := paths.New(url).Base()
+	name = strings.TrimSuffix(name, ".tar.gz")
+	name = strings.TrimSuffix(name, ".tgz")
+	if !strings.HasPrefix(name, pluginPrefix) {
+		name = pluginPrefix + name
+	}
+	return name
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s fetching %s", resp.Status, url)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// loadTrustRoot parses a PEM-encoded RSA public key used to verify plugin
+// signatures.
+func loadTrustRoot(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("not a PEM-encoded public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("trust root is not an RSA public key")
+	}
+	return rsaPub, nil
+}
+
+// verify checks signature against the sha256 digest of archive.
+func verify(pub *rsa.PublicKey, archive, signature []byte) error {
+	digest := sha256.Sum256(archive)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)
+}
@@ -0,0 +1,49 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package plugin
+
+import (
+	"os"
+
+	"github.com/arduino/arduino-cli/cli/errorcodes"
+	"github.com/arduino/arduino-cli/cli/feedback"
+	"github.com/spf13/cobra"
+)
+
+func initListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the installed plugins.",
+		Long:  "List the third-party plugins discovered in the plugins directory.",
+		Args:  cobra.NoArgs,
+		Run:   runListCommand,
+	}
+}
+
+func runListCommand(cmd *cobra.Command, args []string) {
+	plugins, err := Discover()
+	if err != nil {
+		feedback.Errorf("Error listing plugins: %v", err)
+		os.Exit(errorcodes.ErrGeneric)
+	}
+	if len(plugins) == 0 {
+		feedback.Print("No plugins installed.")
+		return
+	}
+	for _, p := range plugins {
+		feedback.Printf("%-15s %-10s %s (%s)", p.Name, p.Metadata.Version, p.Metadata.ShortDescription, p.Metadata.Vendor)
+	}
+}
@@ -0,0 +1,128 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package plugin discovers and runs third-party `arduino-cli-<name>`
+// executables, following the model of Docker's cli-plugins: plugins live
+// in a configurable directory, declare themselves through a `metadata`
+// subcommand, and are surfaced as first-class subcommands of the root
+// cobra tree.
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pluginPrefix is prepended to a plugin's advertised name to get its
+// executable name on disk, e.g. the "foo" plugin ships as
+// "arduino-cli-foo".
+const pluginPrefix = "arduino-cli-"
+
+// Metadata is the JSON document a plugin must print in response to its
+// `metadata` subcommand.
+type Metadata struct {
+	SchemaVersion    string `json:"schemaVersion"`
+	Vendor           string `json:"vendor"`
+	Version          string `json:"version"`
+	ShortDescription string `json:"shortDescription"`
+}
+
+// Plugin is a discovered plugin executable, together with the metadata it
+// reported.
+type Plugin struct {
+	Name     string
+	Path     *paths.Path
+	Metadata Metadata
+}
+
+// Dir returns the directory plugins are discovered in, defaulting to
+// ~/.arduino15/cli-plugins unless overridden by the directories.plugins
+// setting (--plugins-dir).
+func Dir() *paths.Path {
+	if dir := viper.GetString("directories.plugins"); dir != "" {
+		return paths.New(dir)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return paths.New(".arduino15", "cli-plugins")
+	}
+	return paths.New(home, ".arduino15", "cli-plugins")
+}
+
+// NewCommand created a new `plugin` command, grouping plugin management
+// subcommands.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Manage third-party arduino-cli plugins.",
+		Long:  "Manage third-party arduino-cli plugins installed under the plugins directory.",
+	}
+	cmd.AddCommand(initListCommand())
+	cmd.AddCommand(initInstallCommand())
+	return cmd
+}
+
+// Discover scans Dir() for executables named "arduino-cli-<name>" and
+// queries each one's `metadata` subcommand.
+func Discover() ([]*Plugin, error) {
+	dir := Dir()
+	if !dir.Exist() {
+		return nil, nil
+	}
+	entries, err := dir.ReadDir()
+	if err != nil {
+		return nil, err
+	}
+	entries.FilterOutDirs()
+
+	var plugins []*Plugin
+	for _, entry := range entries {
+		name := entry.Base()
+		if !strings.HasPrefix(name, pluginPrefix) {
+			continue
+		}
+		meta, err := loadMetadata(entry)
+		if err != nil {
+			// Skip plugins that don't answer `metadata` correctly rather
+			// than failing the whole discovery pass.
+			continue
+		}
+		plugins = append(plugins, &Plugin{
+			Name:     strings.TrimPrefix(name, pluginPrefix),
+			Path:     entry,
+			Metadata: meta,
+		})
+	}
+	return plugins, nil
+}
+
+// loadMetadata runs `<path> metadata` and parses its JSON output.
+func loadMetadata(path *paths.Path) (Metadata, error) {
+	out, err := exec.Command(path.String(), "metadata").Output()
+	if err != nil {
+		return Metadata{}, err
+	}
+	var meta Metadata
+	if err := json.Unmarshal(out, &meta); err != nil {
+		return Metadata{}, err
+	}
+	return meta, nil
+}
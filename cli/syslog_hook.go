@@ -0,0 +1,53 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+//go:build !windows
+
+package cli
+
+import (
+	"log/syslog"
+
+	"github.com/sirupsen/logrus"
+	logrus_syslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// syslogPriorities maps the --log-syslog-priority flag's accepted values
+// to their syslog.Priority, mirroring the names syslog.conf(5) itself uses.
+var syslogPriorities = map[string]syslog.Priority{
+	"emerg":   syslog.LOG_EMERG,
+	"alert":   syslog.LOG_ALERT,
+	"crit":    syslog.LOG_CRIT,
+	"err":     syslog.LOG_ERR,
+	"warning": syslog.LOG_WARNING,
+	"notice":  syslog.LOG_NOTICE,
+	"info":    syslog.LOG_INFO,
+	"debug":   syslog.LOG_DEBUG,
+}
+
+// newSyslogHook dials the local syslog daemon (network and address empty)
+// or a remote one (network "udp"/"tcp", address "host:port") and wraps it
+// in a logrus hook tagged tag, logged at priority (an syslogPriorities key;
+// "info" if empty or unrecognized).
+func newSyslogHook(network, address, priority, tag string) (logrus.Hook, error) {
+	level, ok := syslogPriorities[priority]
+	if !ok {
+		level = syslog.LOG_INFO
+	}
+	if tag == "" {
+		tag = "arduino-cli"
+	}
+	return logrus_syslog.NewSyslogHook(network, address, level|syslog.LOG_DAEMON, tag)
+}
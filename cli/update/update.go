@@ -24,8 +24,10 @@ import (
 	"github.com/arduino/arduino-cli/cli/instance"
 	"github.com/arduino/arduino-cli/cli/output"
 	"github.com/arduino/arduino-cli/commands"
+	"github.com/arduino/arduino-cli/commands/lib"
 	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
 	"github.com/arduino/arduino-cli/table"
+	paths "github.com/arduino/go-paths-helper"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -33,12 +35,13 @@ import (
 // NewCommand creates a new `update` command
 func NewCommand() *cobra.Command {
 	updateCommand := &cobra.Command{
-		Use:     "update",
-		Short:   "Updates the index of cores and libraries",
-		Long:    "Updates the index of cores and libraries to the latest versions.",
-		Example: "  " + os.Args[0] + " update",
-		Args:    cobra.NoArgs,
-		Run:     runUpdateCommand,
+		Use:   "update [sketchPath]",
+		Short: "Updates the index of cores and libraries",
+		Long:  "Updates the index of cores and libraries to the latest versions.",
+		Example: "  " + os.Args[0] + " update" +
+			"\n  " + os.Args[0] + " update --show-outdated MySketch",
+		Args: cobra.MaximumNArgs(1),
+		Run:  runUpdateCommand,
 	}
 	updateCommand.Flags().BoolVar(&updateFlags.showOutdated, "show-outdated", false, "Show outdated cores and libraries after index update")
 	return updateCommand
@@ -88,6 +91,29 @@ func runUpdateCommand(cmd *cobra.Command, args []string) {
 			}
 			feedback.Print(tab.Render())
 		}
+
+		// If a sketch was given, also flag any library whose installed
+		// version has drifted from what the sketch's sketch.lock pinned,
+		// the same way the tables above flag drift against the index.
+		if len(args) == 1 {
+			lm := commands.GetLibraryManager(instance.GetId())
+			pm := commands.GetPackageManager(instance.GetId())
+			if lm == nil {
+				feedback.Errorf("Error checking sketch.lock drift: invalid instance")
+			} else {
+				drifted, err := lib.DetectDrift(lm, pm, paths.New(args[0]))
+				if err != nil {
+					feedback.Errorf("Error checking sketch.lock drift: %v", err)
+				} else if len(drifted) > 0 {
+					tab = table.New()
+					tab.SetHeader("Library name")
+					for _, name := range drifted {
+						tab.AddRow(name)
+					}
+					feedback.Print(tab.Render())
+				}
+			}
+		}
 	}
 
 	logrus.Info("Done")
@@ -32,6 +32,11 @@ import (
 type installedLib struct {
 	Library   *libraries.Library
 	Available *librariesindex.Release
+
+	// Resolved carries the checksum/URL recorded for this library the last
+	// time a sketch.lock was written (see lockfile.go). Left nil until
+	// WriteSketchLock runs.
+	Resolved *ResolvedLibrary
 }
 
 // LibraryList FIXMEDOC
@@ -0,0 +1,331 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/arduino/arduino-cli/arduino/cores"
+	"github.com/arduino/arduino-cli/arduino/cores/packagemanager"
+	"github.com/arduino/arduino-cli/arduino/libraries/librariesmanager"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// sketchLockFileName is the name of the lockfile written next to a sketch.
+const sketchLockFileName = "sketch.lock"
+
+// LockedLibrary is a single library entry in a SketchLock.
+type LockedLibrary struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	SourceURL string `json:"source_url,omitempty"`
+	Checksum  string `json:"checksum"`
+}
+
+// LockedPlatform pins the core platform used to build the sketch.
+type LockedPlatform struct {
+	ID       string `json:"id"`
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"`
+}
+
+// LockedTool pins a tool required by the locked platform.
+type LockedTool struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"`
+}
+
+// SketchLock is the Gopkg.lock-style manifest written to sketch.lock after a
+// successful compile. It records the exact resolved versions of every
+// library, the core platform and the tools that produced the build.
+// VerifyLockfile/DetectDrift check an installation against it; there is no
+// installer for it yet (see their doc comments).
+type SketchLock struct {
+	Libraries []*LockedLibrary `json:"libraries"`
+	Platform  *LockedPlatform  `json:"platform,omitempty"`
+	Tools     []*LockedTool    `json:"tools,omitempty"`
+}
+
+// ResolvedLibrary carries the checksum/URL metadata resolved while building
+// a sketch.lock entry for an installed library.
+type ResolvedLibrary struct {
+	Checksum  string
+	SourceURL string
+}
+
+// WriteSketchLock computes a SketchLock for the given library manager,
+// platform and tools, and writes it as sketchPath's sketch.lock.
+func WriteSketchLock(lm *librariesmanager.LibrariesManager, targetPlatform *cores.PlatformRelease, requiredTools []*cores.ToolRelease, sketchPath *paths.Path) error {
+	lock := &SketchLock{}
+
+	for _, installed := range listLibraries(lm, false, true) {
+		checksum, err := checksumDir(installed.Library.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing library %s", installed.Library.Name)
+		}
+		entry := &LockedLibrary{
+			Name:     installed.Library.Name,
+			Version:  installed.Library.Version.String(),
+			Checksum: checksum,
+		}
+		if installed.Available != nil {
+			entry.SourceURL = installed.Available.Resource.URL
+		}
+		installed.Resolved = &ResolvedLibrary{Checksum: checksum, SourceURL: entry.SourceURL}
+		lock.Libraries = append(lock.Libraries, entry)
+	}
+	sort.Slice(lock.Libraries, func(i, j int) bool { return lock.Libraries[i].Name < lock.Libraries[j].Name })
+
+	if targetPlatform != nil {
+		checksum, err := checksumDir(targetPlatform.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing platform %s", targetPlatform)
+		}
+		lock.Platform = &LockedPlatform{
+			ID:       targetPlatform.String(),
+			Version:  targetPlatform.Version.String(),
+			Checksum: checksum,
+		}
+	}
+
+	for _, tool := range requiredTools {
+		checksum, err := checksumDir(tool.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing tool %s", tool)
+		}
+		lock.Tools = append(lock.Tools, &LockedTool{
+			Name:     tool.String(),
+			Version:  tool.Version.String(),
+			Checksum: checksum,
+		})
+	}
+	sort.Slice(lock.Tools, func(i, j int) bool { return lock.Tools[i].Name < lock.Tools[j].Name })
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sketchPath.Join(sketchLockFileName).WriteFile(data)
+}
+
+// ReadSketchLock reads and parses the sketch.lock next to sketchPath.
+func ReadSketchLock(sketchPath *paths.Path) (*SketchLock, error) {
+	data, err := sketchPath.Join(sketchLockFileName).ReadFile()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	lock := &SketchLock{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return lock, nil
+}
+
+// VerifyLockfile checks that every library, and (when pm is non-nil) the
+// platform and tools, recorded in sketchPath's sketch.lock are installed and
+// still match the checksums that were locked. It does not install or
+// otherwise modify anything: there is no installer wired up for sketch.lock
+// in this tree yet (that needs a `commands/core` counterpart, `lib restore`/
+// `core restore` CLI commands and a daemon RPC, none of which exist here),
+// so a locked-but-missing library, platform or tool is reported as an error
+// rather than fetched.
+func VerifyLockfile(lm *librariesmanager.LibrariesManager, pm *packagemanager.PackageManager, sketchPath *paths.Path) error {
+	lock, err := ReadSketchLock(sketchPath)
+	if err != nil {
+		return err
+	}
+
+	installedByName := map[string]*installedLib{}
+	for _, installed := range listLibraries(lm, false, true) {
+		installedByName[installed.Library.Name] = installed
+	}
+
+	for _, locked := range lock.Libraries {
+		installed, ok := installedByName[locked.Name]
+		if !ok {
+			return errors.Errorf("library %s@%s is locked but not installed", locked.Name, locked.Version)
+		}
+		checksum, err := checksumDir(installed.Library.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing library %s", locked.Name)
+		}
+		if checksum != locked.Checksum {
+			return errors.Errorf("checksum mismatch for library %s: locked %s, installed %s", locked.Name, locked.Checksum, checksum)
+		}
+	}
+
+	return verifyPlatformAndTools(pm, lock)
+}
+
+// DetectDrift compares the currently installed libraries, and (when pm is
+// non-nil) the platform and tools, against the ones recorded in
+// sketchPath's sketch.lock, returning the names of the ones whose installed
+// version no longer matches what was locked. It is meant to be surfaced
+// through the existing Outdated / `update --show-outdated` flow.
+func DetectDrift(lm *librariesmanager.LibrariesManager, pm *packagemanager.PackageManager, sketchPath *paths.Path) ([]string, error) {
+	lock, err := ReadSketchLock(sketchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	installedByName := map[string]*installedLib{}
+	for _, installed := range listLibraries(lm, false, true) {
+		installedByName[installed.Library.Name] = installed
+	}
+
+	var drifted []string
+	for _, locked := range lock.Libraries {
+		installed, ok := installedByName[locked.Name]
+		if !ok || installed.Library.Version.String() != locked.Version {
+			drifted = append(drifted, locked.Name)
+		}
+	}
+
+	if pm == nil {
+		return drifted, nil
+	}
+
+	if lock.Platform != nil {
+		release := findInstalledPlatformRelease(pm, lock.Platform.ID)
+		if release == nil || release.Version.String() != lock.Platform.Version {
+			drifted = append(drifted, lock.Platform.ID)
+		}
+	}
+	installedTools := installedToolReleasesByName(pm)
+	for _, locked := range lock.Tools {
+		release, ok := installedTools[locked.Name]
+		if !ok || release.Version.String() != locked.Version {
+			drifted = append(drifted, locked.Name)
+		}
+	}
+
+	return drifted, nil
+}
+
+// verifyPlatformAndTools checks lock's Platform and Tools entries, if any,
+// against pm's currently installed releases. A nil pm only works when lock
+// pins neither, since verifying either requires resolving it against the
+// package manager's installed releases.
+func verifyPlatformAndTools(pm *packagemanager.PackageManager, lock *SketchLock) error {
+	if lock.Platform == nil && len(lock.Tools) == 0 {
+		return nil
+	}
+	if pm == nil {
+		return errors.New("sketch.lock pins a platform or tools but no package manager instance was given to verify them")
+	}
+
+	if lock.Platform != nil {
+		release := findInstalledPlatformRelease(pm, lock.Platform.ID)
+		if release == nil {
+			return errors.Errorf("platform %s is locked but not installed", lock.Platform.ID)
+		}
+		checksum, err := checksumDir(release.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing platform %s", lock.Platform.ID)
+		}
+		if checksum != lock.Platform.Checksum {
+			return errors.Errorf("checksum mismatch for platform %s: locked %s, installed %s", lock.Platform.ID, lock.Platform.Checksum, checksum)
+		}
+	}
+
+	installedTools := installedToolReleasesByName(pm)
+	for _, locked := range lock.Tools {
+		release, ok := installedTools[locked.Name]
+		if !ok {
+			return errors.Errorf("tool %s is locked but not installed", locked.Name)
+		}
+		checksum, err := checksumDir(release.InstallDir)
+		if err != nil {
+			return errors.Wrapf(err, "hashing tool %s", locked.Name)
+		}
+		if checksum != locked.Checksum {
+			return errors.Errorf("checksum mismatch for tool %s: locked %s, installed %s", locked.Name, locked.Checksum, checksum)
+		}
+	}
+	return nil
+}
+
+// findInstalledPlatformRelease returns pm's currently installed platform
+// release whose String() matches id (the same String() WriteSketchLock
+// recorded LockedPlatform.ID from), or nil if none does.
+func findInstalledPlatformRelease(pm *packagemanager.PackageManager, id string) *cores.PlatformRelease {
+	for _, targetPackage := range pm.Packages {
+		for _, platform := range targetPackage.Platforms {
+			if release := pm.GetInstalledPlatformRelease(platform); release != nil && release.String() == id {
+				return release
+			}
+		}
+	}
+	return nil
+}
+
+// installedToolReleasesByName indexes pm's currently installed tool
+// releases by String(), the same String() WriteSketchLock recorded
+// LockedTool.Name from.
+func installedToolReleasesByName(pm *packagemanager.PackageManager) map[string]*cores.ToolRelease {
+	installed := map[string]*cores.ToolRelease{}
+	for _, targetPackage := range pm.Packages {
+		for _, tool := range targetPackage.Tools {
+			for _, release := range tool.Releases {
+				if release.IsInstalled() {
+					installed[release.String()] = release
+				}
+			}
+		}
+	}
+	return installed
+}
+
+// checksumDir computes a deterministic sha256 over every regular file in
+// dir, hashing filenames in sorted order so the result doesn't depend on
+// filesystem iteration order.
+func checksumDir(dir *paths.Path) (string, error) {
+	if dir == nil {
+		return "", errors.New("install directory is not known")
+	}
+	files, err := dir.ReadDirRecursive()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	files.FilterOutDirs()
+	sort.Slice(files, func(i, j int) bool { return files[i].String() < files[j].String() })
+
+	h := sha256.New()
+	for _, file := range files {
+		rel, err := file.RelTo(dir)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		fmt.Fprintln(h, rel)
+		f, err := file.Open()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
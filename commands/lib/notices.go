@@ -0,0 +1,90 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// noticeFileNames are tried, in order, for every library's folder when
+// looking for its license text. Most libraries ship one of these; if none
+// is found the library's `License` field (parsed from library.properties)
+// is printed on its own instead.
+var noticeFileNames = []string{"LICENSE", "LICENSE.txt", "LICENSE.md", "NOTICE", "NOTICE.txt"}
+
+// Notices concatenates the license/notice text of every library linked
+// into cmb's build into a single bundle suitable for shipping alongside
+// commercial firmware, as required by the GPL-with-linking-exception
+// clause that covers much of this codebase.
+func Notices(cmb *types.CodeModelBuilder) (string, error) {
+	known := map[string]*types.KnownLibrary{}
+	for _, lib := range cmb.KnownLibraries {
+		known[lib.Name] = lib
+	}
+
+	var out strings.Builder
+	for _, lib := range cmb.Libraries {
+		meta, ok := known[lib.Name]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "==== %s", lib.Name)
+		if meta.Version != "" {
+			fmt.Fprintf(&out, " %s", meta.Version)
+		}
+		if meta.Author != "" {
+			fmt.Fprintf(&out, " by %s", meta.Author)
+		}
+		fmt.Fprintln(&out, " ====")
+
+		text := noticeTextFor(meta)
+		if text == "" {
+			text = fmt.Sprintf("License: %s (no license file found in %s)", orUnknown(meta.License), meta.Folder)
+		}
+		out.WriteString(text)
+		out.WriteString("\n\n")
+	}
+
+	return out.String(), nil
+}
+
+// noticeTextFor returns the contents of the first notice/license file
+// found in meta's folder, or "" if none exists.
+func noticeTextFor(meta *types.KnownLibrary) string {
+	if meta.Folder == "" {
+		return ""
+	}
+	folder := paths.New(meta.Folder)
+	for _, name := range noticeFileNames {
+		data, err := folder.Join(name).ReadFile()
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package sbom generates a bill-of-materials document listing every
+// library, core and toolchain component that actually entered the link
+// step of a sketch build. It is driven by the CodeModelBuilder that the
+// legacy builder already populates, so it only ever reports what was
+// really linked, never what's merely installed.
+package sbom
+
+import (
+	"encoding/json"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// Component describes a single piece of software that was linked into the
+// final binary.
+type Component struct {
+	Type        string   `json:"type"` // "core", "library" or "sketch"
+	Name        string   `json:"name"`
+	Version     string   `json:"version,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	License     string   `json:"license,omitempty"`
+	SourceURL   string   `json:"sourceUrl,omitempty"`
+	ObjectFiles []string `json:"objectFiles,omitempty"`
+}
+
+// Document is the SBOM emitted for a single build. It deliberately mirrors
+// the subset of SPDX's package-list shape that's useful offline (name,
+// version, license, supplier), without requiring an SPDX library that
+// isn't vendored in this tree.
+type Document struct {
+	SPDXVersion string      `json:"spdxVersion"`
+	Name        string      `json:"name"`
+	Components  []Component `json:"components"`
+}
+
+// Generate builds a Document from cmb, the CodeModelBuilder populated by a
+// completed build. KnownLibraries is consulted to resolve each library's
+// author/license/URL, since CodeModelLibrary itself only tracks what the
+// linker actually saw.
+func Generate(cmb *types.CodeModelBuilder) (*Document, error) {
+	if cmb == nil {
+		return nil, errors.New("no code model available: was the build performed with CodeModelBuilder enabled?")
+	}
+
+	known := map[string]*types.KnownLibrary{}
+	for _, lib := range cmb.KnownLibraries {
+		known[lib.Name] = lib
+	}
+
+	doc := &Document{SPDXVersion: "SPDX-2.2", Name: "sketch-build"}
+
+	if cmb.Sketch != nil {
+		doc.Components = append(doc.Components, Component{
+			Type:        "sketch",
+			Name:        cmb.Sketch.Name,
+			ObjectFiles: objectFilesOf(cmb.Sketch),
+		})
+	}
+
+	if cmb.Core != nil {
+		doc.Components = append(doc.Components, Component{
+			Type:        "core",
+			Name:        cmb.Core.Name,
+			ObjectFiles: objectFilesOf(cmb.Core),
+		})
+	}
+
+	for _, lib := range cmb.Libraries {
+		component := Component{
+			Type:        "library",
+			Name:        lib.Name,
+			ObjectFiles: objectFilesOf(lib),
+		}
+		if meta, ok := known[lib.Name]; ok {
+			component.Version = meta.Version
+			component.Author = meta.Author
+			component.License = meta.License
+			component.SourceURL = meta.URL
+		}
+		doc.Components = append(doc.Components, component)
+	}
+
+	return doc, nil
+}
+
+// objectFilesOf collects the set of object files a CodeModelLibrary
+// contributed to the link step, one per GCC invocation.
+func objectFilesOf(lib *types.CodeModelLibrary) []string {
+	var files []string
+	for _, inv := range lib.Invocations {
+		if inv.ObjectFile != "" {
+			files = append(files, inv.ObjectFile)
+		}
+	}
+	return files
+}
+
+// WriteFile generates the SBOM for cmb and writes it as indented JSON to
+// path, implementing the `compile --sbom=<path>` option.
+func WriteFile(cmb *types.CodeModelBuilder, path *paths.Path) error {
+	doc, err := Generate(cmb)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return path.WriteFile(data)
+}
@@ -0,0 +1,160 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// LockfileName is the reproducible-build lockfile written next to a
+// sketch's .ino file.
+const LockfileName = "arduino-lock.yaml"
+
+// LockedComponent pins the name, resolved version and (if relevant) install
+// path of a single build input: a platform, a tool, a referenced core or a
+// library.
+type LockedComponent struct {
+	Name        string `mapstructure:"name" yaml:"name"`
+	Version     string `mapstructure:"version" yaml:"version"`
+	InstallPath string `mapstructure:"install_path,omitempty" yaml:"install_path,omitempty"`
+}
+
+// Lockfile is a per-sketch peer of Store: where Store records read-only,
+// installation-wide metadata, a Lockfile records the exact platform, tools,
+// referenced cores and libraries a single sketch was last built with, plus
+// the FQBN (options included) that was in effect, so a later build of the
+// same sketch can fail fast instead of silently drifting from a
+// reproducible build.
+//
+// This is a distinct mechanism from commands/lib's sketch.lock: that one
+// pins library versions/checksums for `lib`/`core` to restore from later,
+// at the package-manager level, and is checked explicitly (VerifyLockfile,
+// DetectDrift) or via `update --show-outdated`. This one is checked and
+// rewritten implicitly by the legacy builder itself (checkLockfile,
+// updateLockfile in legacy/builder/phases/core_builder.go) as part of
+// compiling, and its cache key feeds GetCachedCoreArchiveFileName. They are
+// not yet reconciled into a single lockfile format; until they are, treat
+// sketch.lock as the one covering library restore and this one as the one
+// covering the legacy builder's core archive cache.
+type Lockfile struct {
+	store      *viper.Viper
+	sketchPath string
+	locked     bool
+}
+
+// OpenLockfile loads sketchPath's arduino-lock.yaml, if any. A missing
+// lockfile is not an error: Locked reports false and every accessor
+// returns its zero value, so callers can tell "never locked" from "locked
+// but currently unsatisfied".
+func OpenLockfile(sketchPath string) (*Lockfile, error) {
+	store := viper.New()
+	store.SetConfigName(LockfileName)
+	store.SetConfigType("yaml")
+	store.AddConfigPath(sketchPath)
+
+	lf := &Lockfile{store: store, sketchPath: sketchPath}
+	if err := store.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			return lf, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", LockfileName, err)
+	}
+	lf.locked = true
+	return lf, nil
+}
+
+// Locked reports whether sketchPath already had an arduino-lock.yaml when
+// this Lockfile was opened.
+func (lf *Lockfile) Locked() bool {
+	return lf.locked
+}
+
+// Platform returns the locked platform, the zero LockedComponent if Locked
+// is false.
+func (lf *Lockfile) Platform() LockedComponent {
+	var c LockedComponent
+	_ = lf.store.UnmarshalKey("platform", &c)
+	return c
+}
+
+// Tools returns the locked tools.
+func (lf *Lockfile) Tools() []LockedComponent {
+	return lf.componentsAt("tools")
+}
+
+// Cores returns the locked referenced cores (cores used by the sketch
+// other than the platform it's compiled for, e.g. via #include of a core
+// belonging to a different package).
+func (lf *Lockfile) Cores() []LockedComponent {
+	return lf.componentsAt("cores")
+}
+
+// Libraries returns the locked libraries.
+func (lf *Lockfile) Libraries() []LockedComponent {
+	return lf.componentsAt("libraries")
+}
+
+func (lf *Lockfile) componentsAt(key string) []LockedComponent {
+	var components []LockedComponent
+	_ = lf.store.UnmarshalKey(key, &components)
+	return components
+}
+
+// FQBN returns the FQBN (with whatever menu options were in effect) the
+// sketch was last built with.
+func (lf *Lockfile) FQBN() string {
+	return lf.store.GetString("fqbn")
+}
+
+// CoreArchiveFileName returns the cached core_*.a archive filename (see
+// phases.GetCachedCoreArchiveFileName) the locked platform last built, if
+// any was recorded.
+func (lf *Lockfile) CoreArchiveFileName() string {
+	return lf.store.GetString("core_archive_file_name")
+}
+
+// Update overwrites this Lockfile's contents and writes it to sketchPath's
+// arduino-lock.yaml, creating the file (and sketchPath, if missing) as
+// needed.
+func (lf *Lockfile) Update(platform LockedComponent, tools, cores, libraries []LockedComponent, fqbn string, coreArchiveFileName string) error {
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	sort.Slice(cores, func(i, j int) bool { return cores[i].Name < cores[j].Name })
+	sort.Slice(libraries, func(i, j int) bool { return libraries[i].Name < libraries[j].Name })
+
+	lf.store.Set("platform", platform)
+	lf.store.Set("tools", tools)
+	lf.store.Set("cores", cores)
+	lf.store.Set("libraries", libraries)
+	lf.store.Set("fqbn", fqbn)
+	lf.store.Set("core_archive_file_name", coreArchiveFileName)
+	lf.store.Set("updated_at", time.Now().Format(time.RFC3339))
+
+	if err := os.MkdirAll(lf.sketchPath, 0755); err != nil {
+		return fmt.Errorf("creating sketch dir: %w", err)
+	}
+	configFilePath := filepath.Join(lf.sketchPath, LockfileName)
+	if err := lf.store.WriteConfigAs(configFilePath); err != nil {
+		return fmt.Errorf("writing %s: %w", LockfileName, err)
+	}
+	lf.locked = true
+	return nil
+}
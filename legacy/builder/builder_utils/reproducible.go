@@ -0,0 +1,57 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder_utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+)
+
+// ApplyReproducibleBuildSettings mutates cmd so its output no longer
+// depends on the absolute path of the build tree or the current time:
+// it remaps ctx.BuildPath to a fixed prefix via -ffile-prefix-map (which
+// also covers the older -fdebug-prefix-map/-fmacro-prefix-map cases) and
+// exports SOURCE_DATE_EPOCH so timestamp-embedding steps (e.g. archivers)
+// pick a deterministic value. It is a no-op when ctx.Reproducible is
+// false.
+func ApplyReproducibleBuildSettings(ctx *types.Context, cmd *exec.Cmd) {
+	if !ctx.Reproducible || ctx.BuildPath == nil {
+		return
+	}
+
+	const hermeticPrefix = "/build"
+	prefixMap := fmt.Sprintf("-ffile-prefix-map=%s=%s", ctx.BuildPath, hermeticPrefix)
+	cmd.Args = append(cmd.Args, prefixMap)
+
+	epoch := ctx.SourceDateEpoch
+	if epoch == 0 {
+		if ctx.Sketch != nil && ctx.Sketch.MainFile != nil {
+			if stat, err := ctx.Sketch.MainFile.Name.Stat(); err == nil {
+				epoch = stat.ModTime().Unix()
+			}
+		}
+	}
+	if epoch != 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "SOURCE_DATE_EPOCH="+strconv.FormatInt(epoch, 10))
+	}
+}
@@ -16,6 +16,11 @@
 package builder_utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,8 +28,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/arduino/arduino-cli/legacy/builder/compilecache"
 	"github.com/arduino/arduino-cli/legacy/builder/constants"
+	"github.com/arduino/arduino-cli/legacy/builder/scheduler"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	"github.com/arduino/arduino-cli/legacy/builder/utils"
 	"github.com/arduino/go-paths-helper"
@@ -32,6 +40,15 @@ import (
 	"github.com/pkg/errors"
 )
 
+// objectFileCache returns the shared compile cache rooted at
+// ctx.BuildCachePath, or nil if no build cache path is configured.
+func objectFileCache(ctx *types.Context) *compilecache.Cache {
+	if ctx.BuildCachePath == nil {
+		return nil
+	}
+	return compilecache.New(ctx.BuildCachePath, ctx.CompileCacheSizeMB)
+}
+
 func PrintProgressIfProgressEnabledAndMachineLogger(ctx *types.Context) {
 
 	if !ctx.Progress.PrintEnabled {
@@ -200,57 +217,37 @@ func compileFilesWithRecipe(ctx *types.Context, sourcePath *paths.Path, sources
 		return objectFiles, nil
 	}
 	var objectFilesMux sync.Mutex
-	var errorsList []error
-	var errorsMux sync.Mutex
-
-	queue := make(chan *paths.Path)
-	job := func(source *paths.Path) {
-		objectFile, err := compileFileWithRecipe(ctx, sourcePath, source, buildPath, buildProperties, includes, recipe, libraryModel)
-		if err != nil {
-			errorsMux.Lock()
-			errorsList = append(errorsList, err)
-			errorsMux.Unlock()
-		} else {
-			objectFilesMux.Lock()
-			objectFiles.Add(objectFile)
-			objectFilesMux.Unlock()
-		}
-	}
-
-	// Spawn jobs runners
-	var wg sync.WaitGroup
-	jobs := ctx.Jobs
-	if jobs == 0 {
-		jobs = runtime.NumCPU()
-	}
-	for i := 0; i < jobs; i++ {
-		wg.Add(1)
-		go func() {
-			for source := range queue {
-				job(source)
-			}
-			wg.Done()
-		}()
+	var progressMux sync.Mutex
+
+	graph := scheduler.NewGraph()
+	for i, source := range sources {
+		source := source
+		graph.Add(&scheduler.Node{
+			ID: strconv.Itoa(i) + ":" + source.String(),
+			Action: func(context.Context) error {
+				objectFile, err := compileFileWithRecipe(ctx, sourcePath, source, buildPath, buildProperties, includes, recipe, libraryModel)
+				if err != nil {
+					return err
+				}
+				objectFilesMux.Lock()
+				objectFiles.Add(objectFile)
+				objectFilesMux.Unlock()
+
+				// CompleteStep/PrintProgressIfProgressEnabledAndMachineLogger
+				// touch shared, non-atomic state on ctx.Progress, so they need
+				// the same lock every concurrent job goroutine shares.
+				progressMux.Lock()
+				ctx.Progress.CompleteStep()
+				PrintProgressIfProgressEnabledAndMachineLogger(ctx)
+				progressMux.Unlock()
+				return nil
+			},
+		})
 	}
 
-	// Feed jobs until error or done
-	for _, source := range sources {
-		errorsMux.Lock()
-		gotError := len(errorsList) > 0
-		errorsMux.Unlock()
-		if gotError {
-			break
-		}
-		queue <- source
-
-		ctx.Progress.CompleteStep()
-		PrintProgressIfProgressEnabledAndMachineLogger(ctx)
-	}
-	close(queue)
-	wg.Wait()
-	if len(errorsList) > 0 {
+	if err := graph.Run(context.Background(), ctx.Jobs); err != nil {
 		// output the first error
-		return nil, errors.WithStack(errorsList[0])
+		return nil, errors.WithStack(err)
 	}
 	objectFiles.Sort()
 	return objectFiles, nil
@@ -275,14 +272,39 @@ func compileFileWithRecipe(ctx *types.Context, sourcePath *paths.Path, source *p
 		return nil, errors.WithStack(err)
 	}
 
-	objIsUpToDate, err := ObjFileIsUpToDate(ctx, source, objectFile, depsFile)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	var mtimeUpToDate bool
+	if ctx.BuildCacheMode != types.BuildCacheModeHash {
+		mtimeUpToDate, err = ObjFileIsUpToDate(ctx, source, objectFile, depsFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
 	command, err := PrepareCommandForRecipe(properties, recipe, false)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+
+	// BuildCacheModeHash/BuildCacheModeHybrid trust content over
+	// timestamps: a fresh git checkout, a CI cache restore or a stray
+	// `touch` all change mtimes without changing what needs to be
+	// recompiled, which is exactly what mtimeUpToDate gets fooled by.
+	objIsUpToDate := mtimeUpToDate
+	commandLine := strings.Join(command.Args, " ")
+	switch ctx.BuildCacheMode {
+	case types.BuildCacheModeHash:
+		objIsUpToDate, _ = buildCacheFor(buildPath).IsUpToDate(source, objectFile, depsFile, commandLine)
+	case types.BuildCacheModeHybrid:
+		if !objIsUpToDate {
+			objIsUpToDate, _ = buildCacheFor(buildPath).IsUpToDate(source, objectFile, depsFile, commandLine)
+		}
+	}
+	if ctx.DiagnosticSink != nil {
+		// Ask the compiler for machine-readable diagnostics; utils.ExecCommand
+		// falls back to parsing the classic textual format when the
+		// toolchain doesn't understand this flag.
+		command.Args = append(command.Args, "-fdiagnostics-format=json")
+	}
+	ApplyReproducibleBuildSettings(ctx, command)
 	if ctx.CompilationDatabase != nil {
 		ctx.CompilationDatabase.Add(source, command)
 	}
@@ -296,11 +318,42 @@ func compileFileWithRecipe(ctx *types.Context, sourcePath *paths.Path, source *p
 		libraryModel.Invocations = append(libraryModel.Invocations, invocation)
 	}
 
+	updateBuildCache := ctx.BuildCacheMode == types.BuildCacheModeHash || ctx.BuildCacheMode == types.BuildCacheModeHybrid
+
 	if !objIsUpToDate && !ctx.OnlyUpdateCompilationDatabase && libraryModel == nil{
+		cache := objectFileCache(ctx)
+		var digest string
+		if cache != nil {
+			if sourceBytes, err := source.ReadFile(); err == nil {
+				digest = compilecache.Key(sourceBytes, command.Args, properties.Get("compiler.path"))
+				if cached, hit := cache.Lookup(digest); hit {
+					if err := cached.CopyTo(objectFile); err == nil {
+						if ctx.Verbose {
+							logger.Println(constants.LOG_LEVEL_INFO, "Using cached compiled object for: {0}", objectFile)
+						}
+						if updateBuildCache {
+							updateBuildCacheEntry(buildCacheFor(buildPath), source, objectFile, depsFile, commandLine, ctx)
+						}
+						return objectFile, nil
+					}
+				}
+			}
+		}
+
 		_, _, err = utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+
+		if cache != nil && digest != "" {
+			if err := cache.Store(digest, objectFile, command.Args, properties.Get("compiler.path")); err != nil && ctx.Verbose {
+				logger.Println(constants.LOG_LEVEL_INFO, "Unable to update compile cache: {0}", err)
+			}
+		}
+
+		if updateBuildCache {
+			updateBuildCacheEntry(buildCacheFor(buildPath), source, objectFile, depsFile, commandLine, ctx)
+		}
 	} else if ctx.Verbose {
 		if objIsUpToDate {
 			logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_PREVIOUS_COMPILED_FILE, objectFile)
@@ -438,6 +491,242 @@ func ObjFileIsUpToDate(ctx *types.Context, sourceFile, objectFile, dependencyFil
 	return true, nil
 }
 
+// buildCacheFileName is the JSON sidecar, rooted in a build directory,
+// that backs BuildCacheModeHash/BuildCacheModeHybrid's skip-if-unchanged
+// check ahead of invoking the compiler - see BuildCache.
+const buildCacheFileName = ".arduino-build-cache"
+
+// buildCaches holds one *BuildCache per build directory seen so far in
+// this process, keyed by buildPath.String(), so concurrent compiles of
+// files under the same directory (see compileFilesWithRecipe's worker
+// pool) share a single in-memory view instead of each re-reading the
+// sidecar from disk.
+var buildCaches sync.Map
+
+// buildCacheFor returns the BuildCache for buildPath, loading it from disk
+// on first use.
+func buildCacheFor(buildPath *paths.Path) *BuildCache {
+	if v, ok := buildCaches.Load(buildPath.String()); ok {
+		return v.(*BuildCache)
+	}
+	actual, _ := buildCaches.LoadOrStore(buildPath.String(), loadBuildCache(buildPath))
+	return actual.(*BuildCache)
+}
+
+// updateBuildCacheEntry refreshes buildCache's entry for source and saves
+// it to disk, logging (but not failing the build on) any error.
+func updateBuildCacheEntry(buildCache *BuildCache, source, objectFile, depFile *paths.Path, commandLine string, ctx *types.Context) {
+	if err := buildCache.Update(source, objectFile, depFile, commandLine); err != nil {
+		if ctx.Verbose {
+			ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, "Unable to update build cache: {0}", err)
+		}
+		return
+	}
+	if err := buildCache.Save(); err != nil && ctx.Verbose {
+		ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, "Unable to save build cache: {0}", err)
+	}
+}
+
+// buildCacheEntry is the content-hash fingerprint of a previously
+// compiled source file: the exact command line it was compiled with, the
+// hash of its own contents and of every header listed in its dependency
+// file at that time, and the hash of the resulting object file, so a
+// truncated or otherwise corrupted object file can't be mistaken for a
+// valid cache hit.
+type buildCacheEntry struct {
+	CommandLine string            `json:"command_line"`
+	SourceHash  string            `json:"source_hash"`
+	DepHashes   map[string]string `json:"dep_hashes"`
+	ObjectHash  string            `json:"object_hash"`
+}
+
+// BuildCache is a content-hash cache of previously compiled source files
+// within a single build directory, persisted as JSON at
+// buildPath/.arduino-build-cache and mirrored in an in-memory sync.Map
+// for the lifetime of the process. Unlike ObjFileIsUpToDate, which only
+// compares modification times, BuildCache compares content, so it stays
+// correct across git checkouts, CI cache restores or a stray `touch`
+// that leave mtimes misleading but content unchanged.
+type BuildCache struct {
+	path    *paths.Path
+	entries sync.Map // source file path (string) -> *buildCacheEntry
+	dirty   int32
+	saveMu  sync.Mutex // serializes Save's snapshot+marshal+write of path
+}
+
+// loadBuildCache opens the content-hash cache for buildPath, starting
+// empty (every file unverified) if the sidecar is absent or unparsable.
+func loadBuildCache(buildPath *paths.Path) *BuildCache {
+	bc := &BuildCache{path: buildPath.Join(buildCacheFileName)}
+	data, err := bc.path.ReadFile()
+	if err != nil {
+		return bc
+	}
+	var onDisk map[string]*buildCacheEntry
+	if err := json.Unmarshal(data, &onDisk); err != nil {
+		return bc
+	}
+	for k, v := range onDisk {
+		bc.entries.Store(k, v)
+	}
+	return bc
+}
+
+// IsUpToDate reports whether source's previously recorded fingerprint
+// still matches: the same command line, the same content for source and
+// every header listed in depFile, and an object file whose content still
+// matches what was recorded when it was produced.
+func (bc *BuildCache) IsUpToDate(source, objectFile, depFile *paths.Path, commandLine string) (bool, error) {
+	v, ok := bc.entries.Load(source.String())
+	if !ok {
+		return false, nil
+	}
+	entry := v.(*buildCacheEntry)
+	if entry.CommandLine != commandLine || !objectFile.Exist() {
+		return false, nil
+	}
+
+	sourceHash, err := hashFileContents(source)
+	if err != nil {
+		return false, err
+	}
+	if sourceHash != entry.SourceHash {
+		return false, nil
+	}
+
+	deps, err := buildCacheDepFileHeaders(depFile)
+	if err != nil {
+		return false, err
+	}
+	if len(deps) != len(entry.DepHashes) {
+		return false, nil
+	}
+	for _, dep := range deps {
+		recordedHash, ok := entry.DepHashes[dep]
+		if !ok {
+			return false, nil
+		}
+		hash, err := hashFileContents(paths.New(dep))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if hash != recordedHash {
+			return false, nil
+		}
+	}
+
+	objectHash, err := hashFileContents(objectFile)
+	if err != nil {
+		return false, err
+	}
+	return objectHash == entry.ObjectHash, nil
+}
+
+// Update (re)computes and stores the fingerprint for a just-produced
+// objectFile, compiled from source with commandLine.
+func (bc *BuildCache) Update(source, objectFile, depFile *paths.Path, commandLine string) error {
+	sourceHash, err := hashFileContents(source)
+	if err != nil {
+		return err
+	}
+	deps, err := buildCacheDepFileHeaders(depFile)
+	if err != nil {
+		return err
+	}
+	depHashes := make(map[string]string, len(deps))
+	for _, dep := range deps {
+		hash, err := hashFileContents(paths.New(dep))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		depHashes[dep] = hash
+	}
+	objectHash, err := hashFileContents(objectFile)
+	if err != nil {
+		return err
+	}
+
+	bc.entries.Store(source.String(), &buildCacheEntry{
+		CommandLine: commandLine,
+		SourceHash:  sourceHash,
+		DepHashes:   depHashes,
+		ObjectHash:  objectHash,
+	})
+	atomic.StoreInt32(&bc.dirty, 1)
+	return nil
+}
+
+// Save persists the cache to disk if anything changed since it was
+// loaded (or created), and is a no-op otherwise. Update runs once per
+// compiled file, concurrently across up to ctx.Jobs goroutines (see
+// compileFilesWithRecipe), so Save can be called concurrently too; saveMu
+// serializes the snapshot+marshal+write so two overlapping calls can't
+// write the sidecar out of order and have the one with the older snapshot
+// finish last, silently dropping entries the other had already recorded.
+func (bc *BuildCache) Save() error {
+	if atomic.SwapInt32(&bc.dirty, 0) == 0 {
+		return nil
+	}
+	bc.saveMu.Lock()
+	defer bc.saveMu.Unlock()
+
+	onDisk := map[string]*buildCacheEntry{}
+	bc.entries.Range(func(k, v interface{}) bool {
+		onDisk[k.(string)] = v.(*buildCacheEntry)
+		return true
+	})
+	data, err := json.MarshalIndent(onDisk, "", "  ")
+	if err != nil {
+		atomic.StoreInt32(&bc.dirty, 1)
+		return err
+	}
+	if err := bc.path.WriteFile(data); err != nil {
+		atomic.StoreInt32(&bc.dirty, 1)
+		return err
+	}
+	return nil
+}
+
+// hashFileContents returns the hex-encoded SHA-256 of path's contents.
+func hashFileContents(path *paths.Path) (string, error) {
+	data, err := path.ReadFile()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// buildCacheDepFileHeaders parses a .d dependency file using the same row
+// conventions ObjFileIsUpToDate does (one path per line: "objfile:" on
+// the first line, the source file on the second, and one header per
+// subsequent line) and returns the header paths it lists.
+func buildCacheDepFileHeaders(depFile *paths.Path) ([]string, error) {
+	rows, err := depFile.ReadFileAsLines()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows = utils.Map(rows, removeEndingBackSlash)
+	rows = utils.Map(rows, strings.TrimSpace)
+	rows = utils.Map(rows, unescapeDep)
+	rows = utils.Filter(rows, nonEmptyString)
+
+	if len(rows) < 2 || !strings.HasSuffix(rows[0], ":") {
+		return nil, nil
+	}
+	return rows[2:], nil
+}
+
 func unescapeDep(s string) string {
 	s = strings.Replace(s, "\\ ", " ", -1)
 	s = strings.Replace(s, "\\\t", "\t", -1)
@@ -506,6 +795,13 @@ func TXTBuildRulesHaveChanged(corePath, targetCorePath, targetFile *paths.Path)
 	return true
 }
 
+// ArchiveCompiledFiles creates buildPath/archiveFile from
+// objectFilesToArchive. The "archive.mode" build property selects how:
+// "per-object" (the default, and the only mode every ar understands)
+// invokes recipe.ar.pattern once per object file; "batch-mri" and "thin"
+// batch every object into a single ar invocation, which on cores with
+// hundreds of object files turns archiving from a multi-second step into
+// a matter of milliseconds.
 func ArchiveCompiledFiles(ctx *types.Context, buildPath *paths.Path, archiveFile *paths.Path, objectFilesToArchive paths.PathList, buildProperties *properties.Map, libraryModel *types.CodeModelLibrary) (*paths.Path, error) {
 	logger := ctx.GetLogger()
 	archiveFilePath := buildPath.JoinPath(archiveFile)
@@ -541,7 +837,31 @@ func ArchiveCompiledFiles(ctx *types.Context, buildPath *paths.Path, archiveFile
 		}
 	}
 
-	for _, objectFile := range objectFilesToArchive {
+	// Sort so the archive's member order - and so its bytes - doesn't
+	// depend on filesystem iteration order.
+	objectFilesToArchive.Sort()
+
+	var archiveErr error
+	switch buildProperties.Get("archive.mode") {
+	case "thin":
+		archiveErr = archiveThin(ctx, archiveFilePath, objectFilesToArchive, buildProperties)
+	case "batch-mri":
+		archiveErr = archiveBatchMRI(ctx, archiveFilePath, objectFilesToArchive, buildProperties)
+	default:
+		archiveErr = archivePerObject(ctx, archiveFilePath, objectFilesToArchive, buildProperties)
+	}
+	if archiveErr != nil {
+		return nil, errors.WithStack(archiveErr)
+	}
+
+	return archiveFilePath, nil
+}
+
+// archivePerObject invokes recipe.ar.pattern once per object file - the
+// historical behavior, and the only one every ar (even one with no thin
+// archive or MRI script support) is guaranteed to understand.
+func archivePerObject(ctx *types.Context, archiveFilePath *paths.Path, objectFiles paths.PathList, buildProperties *properties.Map) error {
+	for _, objectFile := range objectFiles {
 		properties := buildProperties.Clone()
 		properties.Set(constants.BUILD_PROPERTIES_ARCHIVE_FILE, archiveFilePath.Base())
 		properties.SetPath(constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH, archiveFilePath)
@@ -549,20 +869,141 @@ func ArchiveCompiledFiles(ctx *types.Context, buildPath *paths.Path, archiveFile
 
 		command, err := PrepareCommandForRecipe(properties, constants.RECIPE_AR_PATTERN, false)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return err
 		}
+		ApplyReproducibleBuildSettings(ctx, command)
 
-		_, _, err = utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */)
-		if err != nil {
-			return nil, errors.WithStack(err)
+		if _, _, err := utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return archiveFilePath, nil
+// arToolAndFlags returns the ar binary and the create/extra flags
+// platform.txt configures for it (compiler.ar.flags, e.g. "rcs", and
+// compiler.ar.extra_flags) - the same properties recipe.ar.pattern
+// expands, so archiveThin and archiveBatchMRI stay in sync with whatever
+// a platform defines without having to parse the recipe pattern itself.
+func arToolAndFlags(buildProperties *properties.Map) (toolPath string, createFlags, extraFlags []string) {
+	toolPath = buildProperties.Get("compiler.path") + buildProperties.Get("compiler.ar.cmd")
+	createFlags = strings.Fields(buildProperties.ExpandPropsInString(buildProperties.Get("compiler.ar.flags")))
+	extraFlags = strings.Fields(buildProperties.ExpandPropsInString(buildProperties.Get("compiler.ar.extra_flags")))
+	return toolPath, createFlags, extraFlags
+}
+
+// archiveThin creates archiveFilePath as a single GNU thin archive
+// (ar ... -D -T), referencing every object file in place rather than
+// copying its contents in, in one ar invocation regardless of how many
+// object files there are. -D asks for deterministic (not
+// timestamp/uid/gid-dependent) archive members where ar supports it.
+func archiveThin(ctx *types.Context, archiveFilePath *paths.Path, objectFiles paths.PathList, buildProperties *properties.Map) error {
+	toolPath, createFlags, extraFlags := arToolAndFlags(buildProperties)
+
+	args := append([]string{}, createFlags...)
+	args = append(args, extraFlags...)
+	args = append(args, "-D", "-T", archiveFilePath.String())
+	for _, objectFile := range objectFiles {
+		args = append(args, objectFile.String())
+	}
+
+	command := exec.Command(toolPath, args...)
+	ApplyReproducibleBuildSettings(ctx, command)
+	_, _, err := utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */)
+	return err
+}
+
+// archiveBatchMRI creates archiveFilePath with a single `ar -M`
+// invocation driven by a CREATE/ADDMOD/SAVE MRI script fed on stdin, for
+// toolchains that can batch many objects into one archive but don't
+// support thin archives. Determinism here comes from SOURCE_DATE_EPOCH
+// (see ApplyReproducibleBuildSettings) rather than -D, which has no MRI
+// script equivalent; compiler.ar.flags (the create-mode letters, e.g.
+// "rcs") likewise don't apply under MRI control and are intentionally
+// not passed.
+func archiveBatchMRI(ctx *types.Context, archiveFilePath *paths.Path, objectFiles paths.PathList, buildProperties *properties.Map) error {
+	toolPath, _, extraFlags := arToolAndFlags(buildProperties)
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "CREATE %s\n", archiveFilePath)
+	for _, objectFile := range objectFiles {
+		fmt.Fprintf(&script, "ADDMOD %s\n", objectFile)
+	}
+	script.WriteString("SAVE\nEND\n")
+
+	args := append(append([]string{}, extraFlags...), "-M")
+	command := exec.Command(toolPath, args...)
+	command.Stdin = strings.NewReader(script.String())
+	ApplyReproducibleBuildSettings(ctx, command)
+	_, _, err := utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */)
+	return err
+}
+
+// ArchiveJob is one archive ArchiveLibrariesInParallel should produce.
+type ArchiveJob struct {
+	BuildPath            *paths.Path
+	ArchiveFile          *paths.Path
+	ObjectFilesToArchive paths.PathList
+	BuildProperties      *properties.Map
+	LibraryModel         *types.CodeModelLibrary
+}
+
+// ArchiveLibrariesInParallel runs ArchiveCompiledFiles for each job on a
+// scheduler.Graph of independent nodes, sized the same way
+// compileFilesWithRecipe sizes its worker pool (ctx.Jobs, or
+// runtime.NumCPU if unset): unlike a core's object files, which must all
+// land in the one core.a, each library's archive is entirely independent
+// of every other's, so there's no reason to build them one after another.
+func ArchiveLibrariesInParallel(ctx *types.Context, jobs []ArchiveJob) (paths.PathList, error) {
+	archiveFiles := paths.NewPathList()
+	if len(jobs) == 0 {
+		return archiveFiles, nil
+	}
+
+	var archiveFilesMux sync.Mutex
+
+	graph := scheduler.NewGraph()
+	for i, job := range jobs {
+		job := job
+		graph.Add(&scheduler.Node{
+			ID: strconv.Itoa(i) + ":" + job.ArchiveFile.String(),
+			Action: func(context.Context) error {
+				archiveFilePath, err := ArchiveCompiledFiles(ctx, job.BuildPath, job.ArchiveFile, job.ObjectFilesToArchive, job.BuildProperties, job.LibraryModel)
+				if err != nil {
+					return err
+				}
+				archiveFilesMux.Lock()
+				archiveFiles.Add(archiveFilePath)
+				archiveFilesMux.Unlock()
+				return nil
+			},
+		})
+	}
+
+	if err := graph.Run(context.Background(), ctx.Jobs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	archiveFiles.Sort()
+	return archiveFiles, nil
 }
 
 const COMMANDLINE_LIMIT = 30000
 
+// windowsCommandlineLimit is the threshold used on Windows instead of
+// COMMANDLINE_LIMIT: CreateProcess caps a command line at 32767 chars,
+// but invocations mediated by cmd.exe can be limited to as little as
+// 8191, so shortening needs to kick in much earlier there.
+const windowsCommandlineLimit = 8000
+
+// commandlineLimit returns the expanded-command-line length above which
+// PrepareCommandForRecipe tries to shorten the invocation.
+func commandlineLimit() int {
+	if runtime.GOOS == "windows" {
+		return windowsCommandlineLimit
+	}
+	return COMMANDLINE_LIMIT
+}
+
 func PrepareCommandForRecipe(buildProperties *properties.Map, recipe string, removeUnsetProperties bool) (*exec.Cmd, error) {
 	pattern := buildProperties.Get(recipe)
 	if pattern == "" {
@@ -580,22 +1021,75 @@ func PrepareCommandForRecipe(buildProperties *properties.Map, recipe string, rem
 	}
 	command := exec.Command(parts[0], parts[1:]...)
 
-	// if the overall commandline is too long for the platform
-	// try reducing the length by making the filenames relative
-	// and changing working directory to build.path
-	if len(commandLine) > COMMANDLINE_LIMIT {
-		relativePath := buildProperties.Get("build.path")
-		for i, arg := range command.Args {
-			if _, err := os.Stat(arg); os.IsNotExist(err) {
-				continue
+	// if the overall commandline is too long for the platform, shorten it:
+	// with a toolchain that declares compiler.supports_response_files,
+	// write every argument but argv[0] to a @rspfile; otherwise fall back
+	// to the previous trick of making filenames relative and changing the
+	// working directory to build.path.
+	if len(commandLine) > commandlineLimit() {
+		if buildProperties.Get("compiler.supports_response_files") == "true" {
+			rspFile := paths.New(buildProperties.Get("build.path")).Join(sanitizeRecipeFileName(recipe) + ".rsp")
+			if err := useResponseFile(command, rspFile); err != nil {
+				return nil, errors.WithStack(err)
 			}
-			rel, err := filepath.Rel(relativePath, arg)
-			if err == nil && !strings.Contains(rel, "..") && len(rel) < len(arg) {
-				command.Args[i] = rel
+		} else {
+			relativePath := buildProperties.Get("build.path")
+			for i, arg := range command.Args {
+				if _, err := os.Stat(arg); os.IsNotExist(err) {
+					continue
+				}
+				rel, err := filepath.Rel(relativePath, arg)
+				if err == nil && !strings.Contains(rel, "..") && len(rel) < len(arg) {
+					command.Args[i] = rel
+				}
 			}
+			command.Dir = relativePath
 		}
-		command.Dir = relativePath
 	}
 
 	return command, nil
 }
+
+// useResponseFile rewrites command in place to invoke the same program
+// (command.Args[0]) with a single @rspfile argument standing in for
+// every argument that follows, written one per line to rspFile - the
+// same technique cmd/go and other modern build systems use to stay under
+// a platform's command-line length limit without lossy path shortening.
+func useResponseFile(command *exec.Cmd, rspFile *paths.Path) error {
+	if err := rspFile.Parent().MkdirAll(); err != nil {
+		return err
+	}
+
+	lines := make([]string, len(command.Args)-1)
+	for i, arg := range command.Args[1:] {
+		lines[i] = quoteResponseFileArg(arg)
+	}
+	if err := rspFile.WriteFile([]byte(strings.Join(lines, "\n"))); err != nil {
+		return err
+	}
+
+	command.Args = []string{command.Args[0], "@" + rspFile.String()}
+	return nil
+}
+
+// quoteResponseFileArg double-quotes arg and backslash-escapes any
+// embedded quote or backslash, matching the quoting gcc/ar's own @file
+// argument parser expects.
+func quoteResponseFileArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range arg {
+		if r == '\\' || r == '"' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// sanitizeRecipeFileName turns a recipe key (e.g.
+// "recipe.c.o.pattern") into a plain filename component.
+func sanitizeRecipeFileName(recipe string) string {
+	return strings.NewReplacer(".", "_", ":", "_").Replace(recipe)
+}
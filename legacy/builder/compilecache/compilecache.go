@@ -0,0 +1,205 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package compilecache implements a ccache-style, content-addressed cache
+// for the object files produced while compiling a sketch, a library or a
+// core. Entries are keyed by a digest of the source file contents and the
+// expanded compiler command line, so a cache hit is safe to reuse
+// regardless of where (or when) it was produced, as long as the inputs
+// that influence the resulting object file are unchanged.
+package compilecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// Cache is a shared, on-disk object-file cache rooted at a directory
+// (typically Context.BuildCachePath). Entries are stored as
+// sha256/xx/xxxxxxxx.o, alongside a .json metadata sidecar describing the
+// compiler invocation that produced them.
+type Cache struct {
+	Root *paths.Path
+
+	// SizeLimitMB is the LRU size cap, in megabytes. Zero means unbounded.
+	SizeLimitMB int
+
+	hits   int64
+	misses int64
+
+	mux sync.Mutex
+}
+
+// Metadata is the JSON sidecar stored next to each cached object file.
+type Metadata struct {
+	CompilerArgs []string `json:"compiler_args"`
+	ToolchainID  string   `json:"toolchain_id"`
+	Size         int64    `json:"size"`
+	MTime        int64    `json:"mtime"`
+}
+
+// New creates a Cache rooted at root, applying the given LRU size cap (in
+// megabytes, 0 for unbounded).
+func New(root *paths.Path, sizeLimitMB int) *Cache {
+	return &Cache{Root: root, SizeLimitMB: sizeLimitMB}
+}
+
+// Key computes the digest used to address a cache entry: it combines the
+// preprocessed (or raw) source contents with the normalized compiler flags
+// and a toolchain identifier, so that a change to any of the three causes
+// a cache miss.
+func Key(source []byte, args []string, toolchainID string) string {
+	h := sha256.New()
+	h.Write(source)
+	for _, a := range args {
+		h.Write([]byte{0})
+		h.Write([]byte(a))
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(toolchainID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pathFor returns the object file and metadata sidecar path for a given
+// digest, using a sha256/xx/xxxxx… sharded layout to avoid huge
+// directories.
+func (c *Cache) pathFor(digest string) (objectFile, metaFile *paths.Path) {
+	dir := c.Root.Join("sha256", digest[:2])
+	return dir.Join(digest + ".o"), dir.Join(digest + ".json")
+}
+
+// Lookup returns the cached object file for digest, if present, and
+// updates the hit/miss counters accordingly. The caller is responsible
+// for copying the returned path to the actual build output location.
+func (c *Cache) Lookup(digest string) (*paths.Path, bool) {
+	objectFile, metaFile := c.pathFor(digest)
+	if !objectFile.Exist() || !metaFile.Exist() {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	// Touch the object file, whose mtime the LRU prune below sorts by, so
+	// recently reused entries stay alive.
+	c.mux.Lock()
+	now := time.Now()
+	objectFile.Chtimes(now, now)
+	c.mux.Unlock()
+	atomic.AddInt64(&c.hits, 1)
+	return objectFile, true
+}
+
+// Store copies objectFile into the cache under digest, recording the
+// given compiler invocation metadata, and prunes the cache if it now
+// exceeds SizeLimitMB.
+func (c *Cache) Store(digest string, objectFile *paths.Path, args []string, toolchainID string) error {
+	dst, metaFile := c.pathFor(digest)
+	if err := dst.Parent().MkdirAll(); err != nil {
+		return err
+	}
+	if err := objectFile.CopyTo(dst); err != nil {
+		return err
+	}
+	stat, err := dst.Stat()
+	if err != nil {
+		return err
+	}
+	meta := Metadata{
+		CompilerArgs: args,
+		ToolchainID:  toolchainID,
+		Size:         stat.Size(),
+		MTime:        stat.ModTime().Unix(),
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := metaFile.WriteFile(data); err != nil {
+		return err
+	}
+	return c.prune()
+}
+
+// Stats returns the cumulative hit/miss counts for this cache instance.
+func (c *Cache) Stats() (hits, misses int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+type cacheEntry struct {
+	path  *paths.Path
+	size  int64
+	mtime int64
+}
+
+// prune enforces SizeLimitMB by evicting the least-recently-touched
+// object files (and their sidecars) until the cache fits, or does
+// nothing if SizeLimitMB is zero.
+func (c *Cache) prune() error {
+	if c.SizeLimitMB <= 0 {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var entries []cacheEntry
+	var total int64
+
+	shards, err := c.Root.Join("sha256").ReadDir()
+	if err != nil {
+		return nil // nothing to prune yet
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		files, err := shard.ReadDir()
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.Ext() != ".o" {
+				continue
+			}
+			stat, err := f.Stat()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, cacheEntry{path: f, size: stat.Size(), mtime: stat.ModTime().Unix()})
+			total += stat.Size()
+		}
+	}
+
+	limit := int64(c.SizeLimitMB) * 1024 * 1024
+	if total <= limit {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mtime < entries[j].mtime })
+	for _, e := range entries {
+		if total <= limit {
+			break
+		}
+		meta := paths.New(e.path.String()[:len(e.path.String())-len(".o")] + ".json")
+		e.path.Remove()
+		meta.Remove()
+		total -= e.size
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package builder
 import (
 	bldr "github.com/arduino/arduino-cli/arduino/builder"
 	"github.com/arduino/arduino-cli/legacy/builder/constants"
+	"github.com/arduino/arduino-cli/legacy/builder/prototypes"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	"github.com/pkg/errors"
 )
@@ -25,6 +26,24 @@ import (
 type ContainerAddPrototypes struct{}
 
 func (s *ContainerAddPrototypes) Run(ctx *types.Context) error {
+	if ctx.PrototypeExtractor == nil {
+		// A caller (e.g. a test) may have set ctx.PrototypeExtractor
+		// explicitly; only resolve one otherwise. ctx.PrototypesParser, if
+		// set, picks it directly; otherwise fall back to the
+		// "builder.prototypes_extractor" configuration setting.
+		var extractor types.PrototypeExtractor
+		var err error
+		if ctx.PrototypesParser != "" {
+			extractor, err = prototypes.NewFromParser(ctx.PrototypesParser)
+		} else {
+			extractor, err = prototypes.NewFromSettings()
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		ctx.PrototypeExtractor = extractor
+	}
+
 	// Generate the full pathname for the preproc output file
 	if err := ctx.PreprocPath.MkdirAll(); err != nil {
 		return errors.WithStack(err)
@@ -37,22 +56,66 @@ func (s *ContainerAddPrototypes) Run(ctx *types.Context) error {
 		return errors.WithStack(err)
 	}
 
-	commands := []types.Command{
+	setupCommands := []types.Command{
 		&ReadFileAndStoreInContext{FileToRead: targetFilePath, Target: &ctx.SourceGccMinusE},
 		&FilterSketchSource{Source: &ctx.SourceGccMinusE},
 		&CTagsTargetFileSaver{Source: &ctx.SourceGccMinusE, TargetFileName: constants.FILE_CTAGS_TARGET_FOR_GCC_MINUS_E},
-		&CTagsRunner{},
-		&PrototypesAdder{},
 	}
-
-	for _, command := range commands {
+	for _, command := range setupCommands {
 		PrintRingNameIfDebug(ctx, command)
-		err := command.Run(ctx)
-		if err != nil {
+		if err := command.Run(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// The extractor (ctags or ctx.PrototypeExtractor) only ever depends on
+	// ctx.SourceGccMinusE, the FQBN, the toolchain and the resolved
+	// libraries: on an unchanged sketch it reruns for nothing, which on
+	// large multi-file sketches dominates edit-compile latency. Skip it
+	// whenever preproc/prototypes.cache.json still has a valid entry for
+	// this exact merged source.
+	cacheKey := prototypesCacheKey(ctx.SourceGccMinusE)
+	if cache := loadPrototypesCache(ctx, cacheKey); cache != nil {
+		ctx.PrototypesSection = cache.PrototypesSection
+		ctx.PrototypesLineWhereToInsert = cache.PrototypesLineWhereToInsert
+		ctx.LibrariesResolutionResults = cache.LibrariesResolutionResults
+	} else {
+		var extract types.Command = &CTagsRunner{}
+		if ctx.PrototypeExtractor != nil {
+			extract = &PrototypeExtractorRunner{}
+		}
+		PrintRingNameIfDebug(ctx, extract)
+		if err := extract.Run(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+		entry := PrototypesCacheEntry{
+			PrototypesSection:           ctx.PrototypesSection,
+			PrototypesLineWhereToInsert: ctx.PrototypesLineWhereToInsert,
+			IncludeFolders:              ctx.IncludeFolders.AsStrings(),
+			FQBN:                        ctx.FQBN.String(),
+			ArduinoAPIVersion:           ctx.ArduinoAPIVersion,
+			Libraries:                   currentLibraries(ctx),
+			LibrariesResolutionResults:  ctx.LibrariesResolutionResults,
+			Backend:                     prototypeExtractorBackendName(ctx),
+		}
+		if err := savePrototypesCache(ctx, cacheKey, entry); err != nil {
 			return errors.WithStack(err)
 		}
 	}
 
+	adder := &PrototypesAdder{}
+	PrintRingNameIfDebug(ctx, adder)
+	if err := adder.Run(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx.SourceMap = buildSourceMap(ctx.Source, ctx.PrototypesSection)
+	sourceMapSaver := &SavePreprocessedSourceMap{}
+	PrintRingNameIfDebug(ctx, sourceMapSaver)
+	if err := sourceMapSaver.Run(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
 	if err := bldr.SketchSaveItemCpp(ctx.Sketch.MainFile.Name.String(), []byte(ctx.Source), ctx.SketchBuildPath.String()); err != nil {
 		return errors.WithStack(err)
 	}
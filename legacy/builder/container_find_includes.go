@@ -75,8 +75,10 @@ invalidate the cache: If the results from the preprocessor match the
 entries in the cache, the cache remains valid and can again be used for
 the next (unchanged) file.
 
-The cache file uses the JSON format and contains a list of entries. Each
-entry represents a discovered library and contains:
+The cache is stored on disk as a JSON object keyed by source file (the
+empty key holding the entries - such as the core and variant paths - that
+aren't tied to any particular source file), each mapping to a list of
+entries representing a discovered library:
  - Sourcefile: The source file that the include was found in
  - Include: The included filename found
  - Includepath: The addition to the include path
@@ -88,15 +90,70 @@ There are also some special entries:
  - When a file contains no (more) missing includes, an entry with an
    empty Include and IncludePath is generated.
 
+Parallelism
+
+findIncludesUntilDone runs one source file at a time, typically shelling
+out to gcc via GCCPreprocRunnerForDiscoveringIncludes, so detection across
+a sketch that pulls in many libraries is dominated by that serial chain
+of preprocessor invocations. ContainerFindIncludes.Run instead fans
+independent source files out across a worker pool sized by Context.Jobs
+(see findIncludesParallel), while every mutation of the shared
+Context.IncludeFolders / Context.ImportedLibraries / CollectedSourceFiles
+state, and of the include cache itself, is serialized through a mutex so
+the per-file journals above stay consistent regardless of which order the
+workers happen to finish in.
+
+Pluggable resolution
+
+The gcc-preprocessor-plus-regexp approach above is one way to answer "what
+include does this source file fail to resolve", but it's also possible to
+ask a real C++ frontend instead. When Context.IncludeResolver is set, it
+replaces both GCCPreprocRunnerForDiscoveringIncludes and
+IncludesFinderWithRegExp for files that need a cache miss; the rest of the
+pipeline, including the cache itself, is unchanged. See package includes
+for a types.IncludeResolver backed by clang-scan-deps.
+
+Cache validity mode
+
+By default (Context.BuildCacheMode == "" or BuildCacheModeMTime), a source
+file's cached entries are only trusted when ObjFileIsUpToDate's mtime
+comparison against the object and dependency files says nothing changed -
+exactly as described under Caching above. That comparison is fast, but
+mtimes aren't reliable across git checkouts, `touch`, filesystem copies or
+CI cache restores, so BuildCacheModeHash instead hashes the source file
+and every file in its dependency file and trusts the cache iff every
+recorded hash still matches, independent of any timestamp.
+BuildCacheModeHybrid only pays for the hashing when the mtime check
+already says a file is stale, keeping the fast path for the common case.
+The hashes themselves are kept in a sidecar file next to the JSON journal
+(includes.cache.hashes, see includeHashCache) rather than inside it, since
+the journal format is unrelated to validity mode and has its own
+migration story (see readCache).
+
+Build graph artifact
+
+When Context.DumpIncludeGraph is set, every successful library resolution
+is additionally recorded by an includeGraph (see include_graph.go) and,
+once Run completes, written to include-graph.json under BuildPath: which
+library satisfied each #include, its architecture-match score, and any
+other installed library that also matched but lost out to it. This is
+meant for external tooling (build visualizers, dependency auditors) to
+consume; nothing in arduino-cli itself reads it back.
+
 */
 
 package builder
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/arduino/arduino-cli/arduino/libraries"
@@ -113,11 +170,28 @@ type ContainerFindIncludes struct{}
 func (s *ContainerFindIncludes) Run(ctx *types.Context) error {
 	cachePath := ctx.BuildPath.Join("includes.cache")
 	cache := readCache(cachePath)
+	hashCachePath := ctx.BuildPath.Join("includes.cache.hashes")
+	hashCache := readIncludeHashCache(hashCachePath)
+
+	// graph stays nil (and every includeGraph method on it a no-op) unless
+	// DumpIncludeGraph is set, so collecting it costs nothing by default.
+	var graph *includeGraph
+	if ctx.DumpIncludeGraph {
+		graph = newIncludeGraph()
+	}
+
+	// ctxMutex serializes every mutation of ctx.IncludeFolders,
+	// ctx.ImportedLibraries and ctx.CollectedSourceFiles made while
+	// findIncludesParallel runs findIncludesUntilDone concurrently across
+	// independent source files below. The include cache has its own
+	// locking (see includeCache) and doesn't need it.
+	var ctxMutex sync.Mutex
 
-	appendIncludeFolder(ctx, cache, nil, "", ctx.BuildProperties.GetPath("build.core.path"))
+	appendIncludeFolder(ctx, &ctxMutex, cache, nil, "", ctx.BuildProperties.GetPath("build.core.path"))
 	if ctx.BuildProperties.Get("build.variant.path") != "" {
-		appendIncludeFolder(ctx, cache, nil, "", ctx.BuildProperties.GetPath("build.variant.path"))
+		appendIncludeFolder(ctx, &ctxMutex, cache, nil, "", ctx.BuildProperties.GetPath("build.variant.path"))
 	}
+	cache.ExpectEnd(nil)
 
 	sketch := ctx.Sketch
 	mergedfile, err := types.MakeSourceFile(ctx, sketch, paths.New(sketch.MainFile.Name.Base()+".cpp"))
@@ -127,26 +201,31 @@ func (s *ContainerFindIncludes) Run(ctx *types.Context) error {
 	ctx.CollectedSourceFiles.Push(mergedfile)
 
 	sourceFilePaths := ctx.CollectedSourceFiles
-	queueSourceFilesFromFolder(ctx, sourceFilePaths, sketch, ctx.SketchBuildPath, false /* recurse */)
+	queueSourceFilesFromFolder(ctx, &ctxMutex, sourceFilePaths, sketch, ctx.SketchBuildPath, false /* recurse */)
 	srcSubfolderPath := ctx.SketchBuildPath.Join("src")
 	if srcSubfolderPath.IsDir() {
-		queueSourceFilesFromFolder(ctx, sourceFilePaths, sketch, srcSubfolderPath, true /* recurse */)
+		queueSourceFilesFromFolder(ctx, &ctxMutex, sourceFilePaths, sketch, srcSubfolderPath, true /* recurse */)
 	}
 
-	for !sourceFilePaths.Empty() {
-		err := findIncludesUntilDone(ctx, cache, sourceFilePaths.Pop())
-		if err != nil {
-			cachePath.Remove()
-			return errors.WithStack(err)
-		}
+	if err := findIncludesParallel(ctx, &ctxMutex, cache, hashCache, graph, sourceFilePaths); err != nil {
+		cachePath.Remove()
+		return errors.WithStack(err)
 	}
 
-	// Finalize the cache
-	cache.ExpectEnd()
 	err = writeCache(cache, cachePath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if ctx.BuildCacheMode == types.BuildCacheModeHash || ctx.BuildCacheMode == types.BuildCacheModeHybrid {
+		if err := writeIncludeHashCache(hashCache, hashCachePath); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if ctx.DumpIncludeGraph {
+		if err := graph.WriteTo(ctx.BuildPath.Join("include-graph.json")); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 
 	err = runCommand(ctx, &FailIfImportedLibraryIsWrong{})
 	if err != nil {
@@ -156,13 +235,72 @@ func (s *ContainerFindIncludes) Run(ctx *types.Context) error {
 	return nil
 }
 
+// findIncludesParallel drains sourceFilePaths, fanning each wave of files
+// out across a worker pool sized by ctx.Jobs (defaulting to
+// runtime.NumCPU() when 0), and only starts the next wave once every
+// worker from the previous one is done. A "wave" is needed, rather than a
+// single static job queue, because findIncludesUntilDone can itself push
+// more files onto sourceFilePaths as it discovers libraries - those newly
+// discovered files make up the next wave. Every file within a wave is
+// independent of the others, so they're safe to run concurrently.
+func findIncludesParallel(ctx *types.Context, ctxMutex *sync.Mutex, cache *includeCache, hashCache *includeHashCache, graph *includeGraph, sourceFilePaths *types.UniqueSourceFileQueue) error {
+	jobs := ctx.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	for {
+		ctxMutex.Lock()
+		var wave []types.SourceFile
+		for !sourceFilePaths.Empty() {
+			wave = append(wave, sourceFilePaths.Pop())
+		}
+		ctxMutex.Unlock()
+		if len(wave) == 0 {
+			return nil
+		}
+
+		queue := make(chan types.SourceFile)
+		var wg sync.WaitGroup
+		var errMutex sync.Mutex
+		var firstErr error
+		for i := 0; i < jobs; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for sourceFile := range queue {
+					if err := findIncludesUntilDone(ctx, ctxMutex, cache, hashCache, graph, sourceFile); err != nil {
+						errMutex.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						errMutex.Unlock()
+					}
+				}
+			}()
+		}
+		for _, sourceFile := range wave {
+			queue <- sourceFile
+		}
+		close(queue)
+		wg.Wait()
+
+		if firstErr != nil {
+			return firstErr
+		}
+	}
+}
+
 // Append the given folder to the include path and match or append it to
 // the cache. sourceFilePath and include indicate the source of this
 // include (e.g. what #include line in what file it was resolved from)
 // and should be the empty string for the default include folders, like
-// the core or variant.
-func appendIncludeFolder(ctx *types.Context, cache *includeCache, sourceFilePath *paths.Path, include string, folder *paths.Path) {
+// the core or variant. ctxMutex guards the append to ctx.IncludeFolders;
+// it may be called from multiple goroutines at once.
+func appendIncludeFolder(ctx *types.Context, ctxMutex *sync.Mutex, cache *includeCache, sourceFilePath *paths.Path, include string, folder *paths.Path) {
+	ctxMutex.Lock()
 	ctx.IncludeFolders = append(ctx.IncludeFolders, folder)
+	ctxMutex.Unlock()
 	cache.ExpectEntry(sourceFilePath, include, folder)
 }
 
@@ -190,129 +328,396 @@ func (entry *includeCacheEntry) Equals(other *includeCacheEntry) bool {
 	return entry.String() == other.String()
 }
 
-type includeCache struct {
-	// Are the cache contents valid so far?
+// includeCacheFile is the cached journal for a single source file: the
+// entries recorded against it in a previous run, and, while replaying
+// them, how far that replay has gotten before diverging.
+type includeCacheFile struct {
+	// Are this file's cached entries still valid so far?
 	valid bool
 	// Index into entries of the next entry to be processed. Unused
-	// when the cache is invalid.
+	// when the file's entries are invalid.
 	next    int
 	entries []*includeCacheEntry
 }
 
-// Return the next cache entry. Should only be called when the cache is
-// valid and a next entry is available (the latter can be checked with
-// ExpectFile). Does not advance the cache.
-func (cache *includeCache) Next() *includeCacheEntry {
-	return cache.entries[cache.next]
+// includeCache caches the include-detection "journal" described in this
+// file's package doc comment, keyed by the source file each entry was
+// recorded against (the empty key holds entries with no source file,
+// such as the initial core/variant include folders).
+//
+// Earlier versions kept a single flat, ordered list and a cursor into it,
+// which assumed entries were always replayed in the exact order they
+// were recorded. That assumption breaks once findIncludesParallel runs
+// findIncludesUntilDone concurrently across independent source files:
+// there's no single global order any more, only a per-file one. Keying
+// the cache by source file instead lets each file's journal be validated
+// and appended to independently, and lets every mutation be serialized
+// through a single mutex without forcing any ordering between files.
+type includeCache struct {
+	mutex sync.Mutex
+	files map[string]*includeCacheFile
+	// set once any file's journal has diverged from what's on disk, so
+	// writeCache knows whether the whole cache needs rewriting or can
+	// just be touched.
+	invalidated bool
+}
+
+// cacheKeyFor returns the map key a source file's entries are filed
+// under; nil (used for the default include folders, which aren't tied to
+// any particular source file) maps to "".
+func cacheKeyFor(sourcefile *paths.Path) string {
+	if sourcefile == nil {
+		return ""
+	}
+	return sourcefile.String()
 }
 
-// Check that the next cache entry is about the given file. If it is
-// not, or no entry is available, the cache is invalidated. Does not
+// fileLocked returns (creating if necessary) the includeCacheFile for
+// sourcefile. A newly created journal has no counterpart on disk, so it
+// also marks the cache as needing rewriting. Must be called with
+// cache.mutex held.
+func (cache *includeCache) fileLocked(sourcefile *paths.Path) *includeCacheFile {
+	if cache.files == nil {
+		cache.files = map[string]*includeCacheFile{}
+	}
+	key := cacheKeyFor(sourcefile)
+	f, ok := cache.files[key]
+	if !ok {
+		f = &includeCacheFile{}
+		cache.files[key] = f
+		cache.invalidated = true
+	}
+	return f
+}
+
+// invalidateLocked marks f as invalid, truncating its unconsumed cached
+// entries, and records that the on-disk cache needs rewriting. Must be
+// called with cache.mutex held.
+func (cache *includeCache) invalidateLocked(f *includeCacheFile) {
+	if f.valid {
+		f.valid = false
+		f.entries = f.entries[:f.next]
+		cache.invalidated = true
+	}
+}
+
+// ValidFor returns whether sourcefile's cached entries are still valid to
+// replay from.
+func (cache *includeCache) ValidFor(sourcefile *paths.Path) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.fileLocked(sourcefile).valid
+}
+
+// NextFor returns the next cache entry for sourcefile. Should only be
+// called when ValidFor(sourcefile) and a next entry is available (the
+// latter can be checked with ExpectFile). Does not advance the cache.
+func (cache *includeCache) NextFor(sourcefile *paths.Path) *includeCacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	f := cache.fileLocked(sourcefile)
+	return f.entries[f.next]
+}
+
+// ExpectFile checks that a next entry for sourcefile is actually
+// available. If not, sourcefile's entries are invalidated. Does not
 // advance the cache.
 func (cache *includeCache) ExpectFile(sourcefile *paths.Path) {
-	if cache.valid && (cache.next >= len(cache.entries) || !cache.Next().Sourcefile.EqualsTo(sourcefile)) {
-		cache.valid = false
-		cache.entries = cache.entries[:cache.next]
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	f := cache.fileLocked(sourcefile)
+	if f.valid && f.next >= len(f.entries) {
+		cache.invalidateLocked(f)
 	}
 }
 
-// Check that the next entry matches the given values. If so, advance
-// the cache. If not, the cache is invalidated. If the cache is
-// invalidated, or was already invalid, an entry with the given values
-// is appended.
+// ExpectEntry checks that the next entry for sourcefile matches the given
+// values. If so, its cursor advances. If not, sourcefile's entries are
+// invalidated. If they're invalid (whether just now or already), an
+// entry with the given values is appended.
 func (cache *includeCache) ExpectEntry(sourcefile *paths.Path, include string, librarypath *paths.Path) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	f := cache.fileLocked(sourcefile)
 	entry := &includeCacheEntry{Sourcefile: sourcefile, Include: include, Includepath: librarypath}
-	if cache.valid {
-		if cache.next < len(cache.entries) && cache.Next().Equals(entry) {
-			cache.next++
+	if f.valid {
+		if f.next < len(f.entries) && f.entries[f.next].Equals(entry) {
+			f.next++
 		} else {
-			cache.valid = false
-			cache.entries = cache.entries[:cache.next]
+			cache.invalidateLocked(f)
 		}
 	}
 
-	if !cache.valid {
-		cache.entries = append(cache.entries, entry)
+	if !f.valid {
+		f.entries = append(f.entries, entry)
 	}
 }
 
-// Check that the cache is completely consumed. If not, the cache is
-// invalidated.
-func (cache *includeCache) ExpectEnd() {
-	if cache.valid && cache.next < len(cache.entries) {
-		cache.valid = false
-		cache.entries = cache.entries[:cache.next]
+// ExpectEnd checks that sourcefile's entries are completely consumed. If
+// not, they're invalidated.
+func (cache *includeCache) ExpectEnd(sourcefile *paths.Path) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	f := cache.fileLocked(sourcefile)
+	if f.valid && f.next < len(f.entries) {
+		cache.invalidateLocked(f)
 	}
 }
 
-// Read the cache from the given file
+// Read the cache from the given file. Understands both the current
+// per-source-file format and, for migration, the flat ordered-journal
+// format written by versions prior to parallel include detection.
 func readCache(path *paths.Path) *includeCache {
-	bytes, err := path.ReadFile()
+	data, err := path.ReadFile()
 	if err != nil {
 		// Return an empty, invalid cache
 		return &includeCache{}
 	}
-	result := &includeCache{}
-	err = json.Unmarshal(bytes, &result.entries)
-	if err != nil {
-		// Return an empty, invalid cache
-		return &includeCache{}
+
+	var byFile map[string][]*includeCacheEntry
+	if err := json.Unmarshal(data, &byFile); err != nil {
+		var flat []*includeCacheEntry
+		if err := json.Unmarshal(data, &flat); err != nil {
+			// Return an empty, invalid cache
+			return &includeCache{}
+		}
+		byFile = migrateFlatCache(flat)
 	}
-	result.valid = true
-	return result
+
+	cache := &includeCache{files: map[string]*includeCacheFile{}}
+	for key, entries := range byFile {
+		cache.files[key] = &includeCacheFile{valid: true, entries: entries}
+	}
+	return cache
+}
+
+// migrateFlatCache groups a pre-parallel, single ordered journal by the
+// source file each entry was recorded against, so it can be loaded as a
+// per-file includeCache.
+func migrateFlatCache(flat []*includeCacheEntry) map[string][]*includeCacheEntry {
+	byFile := map[string][]*includeCacheEntry{}
+	for _, entry := range flat {
+		key := cacheKeyFor(entry.Sourcefile)
+		byFile[key] = append(byFile[key], entry)
+	}
+	return byFile
 }
 
-// Write the given cache to the given file if it is invalidated. If the
-// cache is still valid, just update the timestamps of the file.
+// Write the given cache to the given file if any source file's journal
+// was invalidated. If every file's cached entries are still valid, just
+// update the timestamp of the file.
 func writeCache(cache *includeCache, path *paths.Path) error {
-	// If the cache was still valid all the way, just touch its file
-	// (in case any source file changed without influencing the
-	// includes). If it was invalidated, overwrite the cache with
-	// the new contents.
-	if cache.valid {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if !cache.invalidated {
 		path.Chtimes(time.Now(), time.Now())
-	} else {
-		bytes, err := json.MarshalIndent(cache.entries, "", "  ")
+		return nil
+	}
+
+	byFile := map[string][]*includeCacheEntry{}
+	for key, f := range cache.files {
+		byFile[key] = f.entries
+	}
+	bytes, err := json.MarshalIndent(byFile, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := path.WriteFile(bytes); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// includeHashRecord is the hash-based counterpart of the mtime check
+// performed by ObjFileIsUpToDate: the hash of a source file's own
+// contents, plus the hash of every file listed in its dependency file,
+// at the time its cached entries were last found valid.
+type includeHashRecord struct {
+	SourceHash string            `json:"source_hash"`
+	DepHashes  map[string]string `json:"dep_hashes"`
+}
+
+// includeHashCache is the sidecar, keyed by source file, backing
+// BuildCacheModeHash and BuildCacheModeHybrid. It's stored separately
+// from includeCache's JSON journal (as includes.cache.hashes) since the
+// two serve different purposes: the journal records what was found, this
+// records why it's still trusted to be correct.
+type includeHashCache struct {
+	mutex sync.Mutex
+	Files map[string]*includeHashRecord `json:"files"`
+}
+
+// readIncludeHashCache loads the hash sidecar from path, returning an
+// empty cache (every file treated as unverified) if it doesn't exist or
+// can't be parsed.
+func readIncludeHashCache(path *paths.Path) *includeHashCache {
+	data, err := path.ReadFile()
+	if err != nil {
+		return &includeHashCache{Files: map[string]*includeHashRecord{}}
+	}
+	cache := &includeHashCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return &includeHashCache{Files: map[string]*includeHashRecord{}}
+	}
+	if cache.Files == nil {
+		cache.Files = map[string]*includeHashRecord{}
+	}
+	return cache
+}
+
+// writeIncludeHashCache overwrites path with cache's current contents.
+func writeIncludeHashCache(cache *includeHashCache, path *paths.Path) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return path.WriteFile(data)
+}
+
+// ValidFor reports whether sourcePath's recorded hash, and the recorded
+// hash of every dependency Update found in its dependency file, still
+// match what's on disk; depPath itself is not re-read. A source file with no recorded entry (e.g. first run, or one
+// seen only under BuildCacheModeMTime so far) is never valid.
+func (c *includeHashCache) ValidFor(sourcePath, depPath *paths.Path) (bool, error) {
+	c.mutex.Lock()
+	record, ok := c.Files[sourcePath.String()]
+	c.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	sourceHash, err := hashFile(sourcePath)
+	if err != nil {
+		return false, err
+	}
+	if sourceHash != record.SourceHash {
+		return false, nil
+	}
+
+	for depFile, depHash := range record.DepHashes {
+		hash, err := hashFile(paths.New(depFile))
 		if err != nil {
-			return errors.WithStack(err)
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if hash != depHash {
+			return false, nil
 		}
-		err = path.WriteFile(bytes)
+	}
+	return true, nil
+}
+
+// Update (re)computes and stores the hash record for sourcePath, hashing
+// sourcePath itself along with every dependency listed in depPath.
+func (c *includeHashCache) Update(sourcePath, depPath *paths.Path) error {
+	sourceHash, err := hashFile(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	deps, err := depFileSources(depPath)
+	if err != nil {
+		return err
+	}
+	depHashes := make(map[string]string, len(deps))
+	for _, dep := range deps {
+		hash, err := hashFile(paths.New(dep))
 		if err != nil {
-			return errors.WithStack(err)
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
 		}
+		depHashes[dep] = hash
 	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Files[sourcePath.String()] = &includeHashRecord{SourceHash: sourceHash, DepHashes: depHashes}
 	return nil
 }
 
-func findIncludesUntilDone(ctx *types.Context, cache *includeCache, sourceFile types.SourceFile) error {
+// hashFile returns the hex-encoded SHA-256 of path's contents.
+func hashFile(path *paths.Path) (string, error) {
+	data, err := path.ReadFile()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// depFileSources parses a .d dependency file using the same row
+// conventions ObjFileIsUpToDate does (one path per line: "objfile:" on
+// the first line, the source file on the second, and one header per
+// subsequent line) and returns the header paths it lists, skipping the
+// object and source file rows that make up the rule's target and first
+// prerequisite.
+func depFileSources(depPath *paths.Path) ([]string, error) {
+	rows, err := depPath.ReadFileAsLines()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows = utils.Map(rows, func(s string) string { return strings.TrimSuffix(strings.TrimSpace(s), "\\") })
+	rows = utils.Map(rows, strings.TrimSpace)
+	rows = utils.Filter(rows, func(s string) bool { return s != "" })
+
+	if len(rows) == 0 || !strings.HasSuffix(rows[0], ":") || len(rows) < 2 {
+		// Not a well-formed depfile (or one with no headers); nothing to
+		// hash beyond the source file itself.
+		return nil, nil
+	}
+	return rows[2:], nil
+}
+
+func findIncludesUntilDone(ctx *types.Context, ctxMutex *sync.Mutex, cache *includeCache, hashCache *includeHashCache, graph *includeGraph, sourceFile types.SourceFile) error {
 	sourcePath := sourceFile.SourcePath(ctx)
 	targetFilePath := paths.NullPath()
 	depPath := sourceFile.DepfilePath(ctx)
 	objPath := sourceFile.ObjectPath(ctx)
 
-	// TODO: This should perhaps also compare against the
-	// include.cache file timestamp. Now, it only checks if the file
-	// changed after the object file was generated, but if it
-	// changed between generating the cache and the object file,
-	// this could show the file as unchanged when it really is
-	// changed. Changing files during a build isn't really
-	// supported, but any problems from it should at least be
-	// resolved when doing another build, which is not currently the
-	// case.
 	// TODO: This reads the dependency file, but the actual building
 	// does it again. Should the result be somehow cached? Perhaps
 	// remove the object file if it is found to be stale?
-	unchanged, err := builder_utils.ObjFileIsUpToDate(ctx, sourcePath, objPath, depPath)
+	mtimeUnchanged, err := builder_utils.ObjFileIsUpToDate(ctx, sourcePath, objPath, depPath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	// mtimeUnchanged only checks if the file changed after the object
+	// file was generated; if it changed between generating the cache and
+	// the object file, it could wrongly show the file as unchanged.
+	// BuildCacheModeHash (and, on a stale mtime, BuildCacheModeHybrid)
+	// sidesteps that by hashing the source and its dependencies instead,
+	// which is also what makes the cache trustworthy across git
+	// checkouts, `touch`, filesystem copies or CI cache restores that
+	// don't preserve relative mtimes.
+	unchanged := mtimeUnchanged
+	if ctx.BuildCacheMode == types.BuildCacheModeHash ||
+		(ctx.BuildCacheMode == types.BuildCacheModeHybrid && !mtimeUnchanged) {
+		unchanged, err = hashCache.ValidFor(sourcePath, depPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	first := true
 	for {
 		var include string
 		cache.ExpectFile(sourcePath)
 
-		includes := ctx.IncludeFolders
+		ctxMutex.Lock()
+		includes := append(paths.PathList{}, ctx.IncludeFolders...)
+		ctxMutex.Unlock()
 		if library, ok := sourceFile.Origin.(*libraries.Library); ok && library.UtilityDir != nil {
 			includes = append(includes, library.UtilityDir)
 		}
@@ -328,74 +733,119 @@ func findIncludesUntilDone(ctx *types.Context, cache *includeCache, sourceFile t
 			}
 		}
 
-		var preproc_err error
-		var preproc_stderr []byte
+		var diagnostic []byte
 
-		if unchanged && cache.valid {
-			include = cache.Next().Include
+		if unchanged && cache.ValidFor(sourcePath) {
+			include = cache.NextFor(sourcePath).Include
 			if first && ctx.Verbose {
 				ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_CACHED_INCLUDES, sourcePath)
 			}
 		} else {
-			preproc_stderr, preproc_err = GCCPreprocRunnerForDiscoveringIncludes(ctx, sourcePath, targetFilePath, includes)
-			// Unwrap error and see if it is an ExitError.
-			_, is_exit_error := errors.Cause(preproc_err).(*exec.ExitError)
-			if preproc_err == nil {
-				// Preprocessor successful, done
-				include = ""
-			} else if !is_exit_error || preproc_stderr == nil {
-				// Ignore ExitErrors (e.g. gcc returning
-				// non-zero status), but bail out on
-				// other errors
-				return errors.WithStack(preproc_err)
-			} else {
-				include = IncludesFinderWithRegExp(string(preproc_stderr))
-				if include == "" && ctx.Verbose {
-					ctx.GetLogger().Println(constants.LOG_LEVEL_DEBUG, constants.MSG_FIND_INCLUDES_FAILED, sourcePath)
-				}
+			var err error
+			include, diagnostic, err = findMissingInclude(ctx, sourcePath, targetFilePath, includes)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if include == "" && ctx.Verbose {
+				ctx.GetLogger().Println(constants.LOG_LEVEL_DEBUG, constants.MSG_FIND_INCLUDES_FAILED, sourcePath)
 			}
 		}
 
 		if include == "" {
 			// No missing includes found, we're done
 			cache.ExpectEntry(sourcePath, "", nil)
+			cache.ExpectEnd(sourcePath)
+			if ctx.BuildCacheMode == types.BuildCacheModeHash || ctx.BuildCacheMode == types.BuildCacheModeHybrid {
+				if err := hashCache.Update(sourcePath, depPath); err != nil {
+					return errors.WithStack(err)
+				}
+			}
 			return nil
 		}
 
 		library := ResolveLibrary(ctx, include)
 		if library == nil {
 			// Library could not be resolved, show error
-			// err := runCommand(ctx, &GCCPreprocRunner{SourceFilePath: sourcePath, TargetFileName: paths.New(constants.FILE_CTAGS_TARGET_FOR_GCC_MINUS_E), Includes: includes})
-			// return errors.WithStack(err)
-			if preproc_err == nil || preproc_stderr == nil {
-				// Filename came from cache, so run preprocessor to obtain error to show
-				preproc_stderr, preproc_err = GCCPreprocRunnerForDiscoveringIncludes(ctx, sourcePath, targetFilePath, includes)
-				if preproc_err == nil {
-					// If there is a missing #include in the cache, but running
-					// gcc does not reproduce that, there is something wrong.
-					// Returning an error here will cause the cache to be
-					// deleted, so hopefully the next compilation will succeed.
+			if diagnostic == nil {
+				// Filename came from cache, so resolve again to obtain
+				// diagnostic output to show.
+				rerunInclude, rerunDiagnostic, err := findMissingInclude(ctx, sourcePath, targetFilePath, includes)
+				if err == nil && rerunInclude == "" {
+					// If there is a missing #include in the cache, but
+					// resolving again does not reproduce that, there is
+					// something wrong. Returning an error here will cause
+					// the cache to be deleted, so hopefully the next
+					// compilation will succeed.
 					return errors.New("Internal error in cache")
 				}
+				diagnostic = rerunDiagnostic
+			}
+			if diagnostic != nil {
+				os.Stderr.Write(diagnostic)
+			}
+			return errors.Errorf("%s: unresolved #include <%s>: no matching library found", sourcePath, include)
+		}
+
+		if graph != nil {
+			architecture := ctx.BuildProperties.Get("build.architecture")
+			var alternatives libraries.List
+			if ctx.LibrariesResolver != nil {
+				alternatives = ctx.LibrariesResolver.AlternativesFor(include)
 			}
-			os.Stderr.Write(preproc_stderr)
-			return errors.WithStack(preproc_err)
+			graph.record(sourcePath, include, architecture, library, alternatives)
 		}
 
 		// Add this library to the list of libraries, the
 		// include path and queue its source files for further
 		// include scanning
+		ctxMutex.Lock()
 		ctx.ImportedLibraries = append(ctx.ImportedLibraries, library)
-		appendIncludeFolder(ctx, cache, sourcePath, include, library.SourceDir)
+		ctxMutex.Unlock()
+		appendIncludeFolder(ctx, ctxMutex, cache, sourcePath, include, library.SourceDir)
 		sourceDirs := library.SourceDirs()
 		for _, sourceDir := range sourceDirs {
-			queueSourceFilesFromFolder(ctx, ctx.CollectedSourceFiles, library, sourceDir.Dir, sourceDir.Recurse)
+			queueSourceFilesFromFolder(ctx, ctxMutex, ctx.CollectedSourceFiles, library, sourceDir.Dir, sourceDir.Recurse)
 		}
 		first = false
 	}
 }
 
-func queueSourceFilesFromFolder(ctx *types.Context, queue *types.UniqueSourceFileQueue, origin interface{}, folder *paths.Path, recurse bool) error {
+// findMissingInclude finds the first #include in sourcePath that cannot
+// be resolved against includes, returning "" if everything resolves.
+// diagnostic carries whatever raw tool output is available to show the
+// user if the returned include can't subsequently be matched to an
+// installed library. err is returned only when the underlying tool
+// itself failed to run or its output couldn't be parsed at all; an
+// unresolved #include is reported through include, not err.
+//
+// When ctx.IncludeResolver is set, it answers this in place of gcc and
+// IncludesFinderWithRegExp below.
+func findMissingInclude(ctx *types.Context, sourcePath *paths.Path, targetFilePath *paths.Path, includes paths.PathList) (include string, diagnostic []byte, err error) {
+	if ctx.IncludeResolver != nil {
+		include, err = ctx.IncludeResolver.FindMissingInclude(sourcePath, includes)
+		return include, nil, err
+	}
+
+	preprocStderr, preprocErr := GCCPreprocRunnerForDiscoveringIncludes(ctx, sourcePath, targetFilePath, includes)
+	// Unwrap error and see if it is an ExitError.
+	_, isExitError := errors.Cause(preprocErr).(*exec.ExitError)
+	if preprocErr == nil {
+		// Preprocessor successful, no missing include
+		return "", nil, nil
+	}
+	if !isExitError || preprocStderr == nil {
+		// Ignore ExitErrors (e.g. gcc returning non-zero status), but
+		// bail out on other errors
+		return "", nil, preprocErr
+	}
+	return IncludesFinderWithRegExp(string(preprocStderr)), preprocStderr, nil
+}
+
+// queueSourceFilesFromFolder pushes every source file under folder onto
+// queue. ctxMutex guards the push, since queue is ctx.CollectedSourceFiles
+// and this may be called from multiple findIncludesUntilDone goroutines
+// at once.
+func queueSourceFilesFromFolder(ctx *types.Context, ctxMutex *sync.Mutex, queue *types.UniqueSourceFileQueue, origin interface{}, folder *paths.Path, recurse bool) error {
 	extensions := func(ext string) bool { return ADDITIONAL_FILE_VALID_EXTENSIONS_NO_HEADERS[ext] }
 
 	filePaths := []string{}
@@ -409,7 +859,9 @@ func queueSourceFilesFromFolder(ctx *types.Context, queue *types.UniqueSourceFil
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		ctxMutex.Lock()
 		queue.Push(sourceFile)
+		ctxMutex.Unlock()
 	}
 
 	return nil
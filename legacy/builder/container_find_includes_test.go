@@ -0,0 +1,301 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arduino/arduino-cli/arduino/libraries"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIncludeCacheIndependentFiles exercises the same sequence of
+// ExpectFile/ExpectEntry/ExpectEnd calls that two concurrent
+// findIncludesUntilDone goroutines would make for two unrelated source
+// files, interleaved, and checks that one file's journal can't
+// invalidate the other's: a keyed cache validated and appended to
+// independently is the whole point of moving away from the single shared
+// cursor the flat journal used.
+func TestIncludeCacheIndependentFiles(t *testing.T) {
+	cache := &includeCache{}
+	fileA := paths.New("a.cpp")
+	fileB := paths.New("b.cpp")
+
+	// Seed on-disk-equivalent state by replaying as if this were the
+	// first run for both files.
+	cache.ExpectFile(fileA)
+	cache.ExpectEntry(fileA, "Foo.h", paths.New("/libs/Foo"))
+	cache.ExpectEntry(fileA, "", nil)
+	cache.ExpectEnd(fileA)
+
+	cache.ExpectFile(fileB)
+	cache.ExpectEntry(fileB, "Bar.h", paths.New("/libs/Bar"))
+	cache.ExpectEntry(fileB, "", nil)
+	cache.ExpectEnd(fileB)
+
+	require.True(t, cache.files[cacheKeyFor(fileA)].valid)
+	require.True(t, cache.files[cacheKeyFor(fileB)].valid)
+
+	// A second "run" replays the same values for A, but B diverges
+	// (as if B's source changed and the preprocessor found a different
+	// include). Only B's bucket should be invalidated.
+	replay := &includeCache{files: map[string]*includeCacheFile{
+		cacheKeyFor(fileA): {valid: true, entries: cache.files[cacheKeyFor(fileA)].entries},
+		cacheKeyFor(fileB): {valid: true, entries: cache.files[cacheKeyFor(fileB)].entries},
+	}}
+
+	replay.ExpectFile(fileA)
+	require.True(t, replay.ValidFor(fileA))
+	replay.ExpectEntry(fileA, "Foo.h", paths.New("/libs/Foo"))
+	replay.ExpectEntry(fileA, "", nil)
+	replay.ExpectEnd(fileA)
+	require.True(t, replay.ValidFor(fileA))
+
+	replay.ExpectFile(fileB)
+	replay.ExpectEntry(fileB, "Baz.h", paths.New("/libs/Baz"))
+	require.False(t, replay.ValidFor(fileB))
+	replay.ExpectEntry(fileB, "", nil)
+	replay.ExpectEnd(fileB)
+
+	require.True(t, replay.ValidFor(fileA))
+	require.False(t, replay.ValidFor(fileB))
+	require.True(t, replay.invalidated)
+}
+
+// TestIncludeCacheConcurrentMutation runs ExpectFile/ExpectEntry/ExpectEnd
+// for many distinct source files concurrently, the same way
+// findIncludesParallel's worker pool does, and checks every file's
+// journal still ends up valid and with the expected entries. Run with
+// `-race` to catch any unsynchronized access to the shared maps.
+func TestIncludeCacheConcurrentMutation(t *testing.T) {
+	const numFiles = 50
+	files := make([]*paths.Path, numFiles)
+	for i := range files {
+		files[i] = paths.New("src", "file.cpp")
+	}
+	// Give each a unique-enough identity via Join below instead, since
+	// paths.New with the same args would collide in the cache map.
+	for i := range files {
+		files[i] = paths.New("src").Join(paths.New("file.cpp").String() + "." + string(rune('a'+i)))
+	}
+
+	cache := &includeCache{}
+	var wg sync.WaitGroup
+	for _, f := range files {
+		f := f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.ExpectFile(f)
+			cache.ExpectEntry(f, "Lib.h", paths.New("/libs/Lib"))
+			cache.ExpectEntry(f, "", nil)
+			cache.ExpectEnd(f)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, cache.files, numFiles)
+	for _, f := range files {
+		entry := cache.files[cacheKeyFor(f)]
+		require.NotNil(t, entry)
+		require.Len(t, entry.entries, 2)
+		require.Equal(t, "Lib.h", entry.entries[0].Include)
+	}
+}
+
+// TestIncludeCacheMigratesFlatFormat checks that a pre-parallel, flat
+// ordered-journal cache file (a single JSON array) loads correctly,
+// grouped by source file, rather than being discarded as invalid.
+func TestIncludeCacheMigratesFlatFormat(t *testing.T) {
+	fileA := paths.New("a.cpp")
+	flat := []*includeCacheEntry{
+		{Sourcefile: nil, Include: "", Includepath: paths.New("/core")},
+		{Sourcefile: fileA, Include: "Foo.h", Includepath: paths.New("/libs/Foo")},
+		{Sourcefile: fileA, Include: "", Includepath: nil},
+	}
+	data, err := json.Marshal(flat)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cachePath := paths.New(dir).Join("includes.cache")
+	require.NoError(t, cachePath.WriteFile(data))
+
+	cache := readCache(cachePath)
+	require.True(t, cache.ValidFor(nil))
+	require.True(t, cache.ValidFor(fileA))
+	require.Len(t, cache.files[cacheKeyFor(fileA)].entries, 2)
+	require.Equal(t, "Foo.h", cache.files[cacheKeyFor(fileA)].entries[0].Include)
+}
+
+// TestIncludeCacheWriteTouchesWhenFullyValid checks that writeCache only
+// rewrites the on-disk file when some file's journal actually diverged,
+// rather than whenever any file was processed.
+func TestIncludeCacheWriteTouchesWhenFullyValid(t *testing.T) {
+	fileA := paths.New("a.cpp")
+	dir := t.TempDir()
+	cachePath := paths.New(dir).Join("includes.cache")
+
+	seed := &includeCache{}
+	seed.ExpectFile(fileA)
+	seed.ExpectEntry(fileA, "Foo.h", paths.New("/libs/Foo"))
+	seed.ExpectEntry(fileA, "", nil)
+	seed.ExpectEnd(fileA)
+	require.NoError(t, writeCache(seed, cachePath))
+	before, err := cachePath.ReadFile()
+	require.NoError(t, err)
+
+	replay := readCache(cachePath)
+	replay.ExpectFile(fileA)
+	replay.ExpectEntry(fileA, "Foo.h", paths.New("/libs/Foo"))
+	replay.ExpectEntry(fileA, "", nil)
+	replay.ExpectEnd(fileA)
+	require.False(t, replay.invalidated)
+
+	require.NoError(t, writeCache(replay, cachePath))
+	after, err := cachePath.ReadFile()
+	require.NoError(t, err)
+	require.Equal(t, string(before), string(after))
+}
+
+// fakeIncludeResolver is a minimal types.IncludeResolver for exercising
+// findMissingInclude's dispatch without shelling out to gcc or clang.
+type fakeIncludeResolver struct {
+	include string
+	err     error
+}
+
+func (r *fakeIncludeResolver) FindMissingInclude(sourcePath *paths.Path, includes paths.PathList) (string, error) {
+	return r.include, r.err
+}
+
+// TestFindMissingIncludeUsesResolverWhenSet checks that findMissingInclude
+// defers to ctx.IncludeResolver, rather than GCCPreprocRunnerForDiscoveringIncludes,
+// whenever one is set, and passes its result straight through.
+func TestFindMissingIncludeUsesResolverWhenSet(t *testing.T) {
+	ctx := &types.Context{IncludeResolver: &fakeIncludeResolver{include: "Foo.h"}}
+	include, diagnostic, err := findMissingInclude(ctx, paths.New("sketch.ino"), paths.NullPath(), nil)
+	require.NoError(t, err)
+	require.Nil(t, diagnostic)
+	require.Equal(t, "Foo.h", include)
+
+	ctx = &types.Context{IncludeResolver: &fakeIncludeResolver{err: errors.New("scan failed")}}
+	_, _, err = findMissingInclude(ctx, paths.New("sketch.ino"), paths.NullPath(), nil)
+	require.Error(t, err)
+}
+
+// TestIncludeHashCacheDetectsContentChangeAcrossMtime checks that
+// includeHashCache.ValidFor keys validity off file contents, not
+// timestamps: even after the source file's mtime is reset to the past
+// (simulating a git checkout or filesystem copy), a real content change
+// must still be detected, and an unchanged file must still validate.
+func TestIncludeHashCacheDetectsContentChangeAcrossMtime(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := paths.New(dir).Join("sketch.ino.cpp")
+	depPath := paths.New(dir).Join("sketch.ino.cpp.d")
+	headerPath := paths.New(dir).Join("Foo.h")
+
+	require.NoError(t, sourcePath.WriteFile([]byte("#include \"Foo.h\"\n")))
+	require.NoError(t, headerPath.WriteFile([]byte("void foo();\n")))
+	require.NoError(t, depPath.WriteFile([]byte(
+		"sketch.ino.cpp.o:\n"+sourcePath.String()+"\n"+headerPath.String()+"\n")))
+
+	cache := readIncludeHashCache(paths.New(dir).Join("includes.cache.hashes"))
+	require.NoError(t, cache.Update(sourcePath, depPath))
+
+	past := time.Unix(0, 0)
+	require.NoError(t, sourcePath.Chtimes(past, past))
+	require.NoError(t, headerPath.Chtimes(past, past))
+
+	valid, err := cache.ValidFor(sourcePath, depPath)
+	require.NoError(t, err)
+	require.True(t, valid, "unchanged contents should validate regardless of mtime")
+
+	require.NoError(t, headerPath.WriteFile([]byte("void foo(int);\n")))
+	valid, err = cache.ValidFor(sourcePath, depPath)
+	require.NoError(t, err)
+	require.False(t, valid, "a changed dependency must invalidate the cache")
+}
+
+// TestIncludeHashCacheRoundtrips checks that writeIncludeHashCache and
+// readIncludeHashCache preserve a record through a JSON roundtrip.
+func TestIncludeHashCacheRoundtrips(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := paths.New(dir).Join("sketch.ino.cpp")
+	depPath := paths.New(dir).Join("sketch.ino.cpp.d")
+	require.NoError(t, sourcePath.WriteFile([]byte("int main() {}\n")))
+	require.NoError(t, depPath.WriteFile([]byte("sketch.ino.cpp.o:\n"+sourcePath.String()+"\n")))
+
+	hashPath := paths.New(dir).Join("includes.cache.hashes")
+	cache := readIncludeHashCache(hashPath)
+	require.NoError(t, cache.Update(sourcePath, depPath))
+	require.NoError(t, writeIncludeHashCache(cache, hashPath))
+
+	reloaded := readIncludeHashCache(hashPath)
+	valid, err := reloaded.ValidFor(sourcePath, depPath)
+	require.NoError(t, err)
+	require.True(t, valid)
+}
+
+// TestArchitectureScore checks the specificity ranking toIncludeGraphLibrary
+// uses to explain why one library was preferred over another for the same
+// architecture: an explicit match beats the "*" wildcard, which beats no
+// match at all.
+func TestArchitectureScore(t *testing.T) {
+	require.Equal(t, 2, architectureScore(&libraries.Library{Architectures: []string{"avr", "esp32"}}, "avr"))
+	require.Equal(t, 1, architectureScore(&libraries.Library{Architectures: []string{"*"}}, "avr"))
+	require.Equal(t, 0, architectureScore(&libraries.Library{Architectures: []string{"esp32"}}, "avr"))
+}
+
+// TestIncludeGraphRecordAndWriteTo checks that record excludes the
+// resolved library from its own rejected-alternatives list and that
+// WriteTo round-trips the result as JSON.
+func TestIncludeGraphRecordAndWriteTo(t *testing.T) {
+	resolved := &libraries.Library{Name: "Servo", Architectures: []string{"avr"}}
+	rejected := &libraries.Library{Name: "Servo-ESP32", Architectures: []string{"esp32"}}
+
+	graph := newIncludeGraph()
+	graph.record(paths.New("sketch.ino.cpp"), "Servo.h", "avr", resolved, libraries.List{resolved, rejected})
+	require.Len(t, graph.Entries, 1)
+	entry := graph.Entries[0]
+	require.Equal(t, "Servo.h", entry.Include)
+	require.Equal(t, "Servo", entry.ResolvedLibrary.Name)
+	require.Equal(t, 2, entry.ResolvedLibrary.ArchitectureScore)
+	require.Len(t, entry.RejectedAlternatives, 1)
+	require.Equal(t, "Servo-ESP32", entry.RejectedAlternatives[0].Name)
+	require.Equal(t, 0, entry.RejectedAlternatives[0].ArchitectureScore)
+
+	path := paths.New(t.TempDir()).Join("include-graph.json")
+	require.NoError(t, graph.WriteTo(path))
+	data, err := path.ReadFile()
+	require.NoError(t, err)
+	require.Contains(t, string(data), "\"Servo\"")
+}
+
+// TestIncludeGraphRecordIsNilSafe checks that every includeGraph method
+// is a no-op on a nil *includeGraph, so call sites don't need to guard
+// every call on ctx.DumpIncludeGraph themselves.
+func TestIncludeGraphRecordIsNilSafe(t *testing.T) {
+	var graph *includeGraph
+	require.NotPanics(t, func() {
+		graph.record(paths.New("sketch.ino.cpp"), "Servo.h", "avr", &libraries.Library{Name: "Servo"}, nil)
+	})
+}
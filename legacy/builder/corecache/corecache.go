@@ -0,0 +1,94 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package corecache abstracts the storage backend behind the compiled
+// core.a cache. A Cache is addressed by a content hash (see ComputeKey)
+// covering everything that can change the resulting archive, so a hit is
+// safe to reuse regardless of which machine produced it or where its
+// source tree happens to live on disk. The local, on-disk backend
+// (LocalCache) preserves the historical behaviour; HTTPCache lets a CI
+// fleet or a classroom lab share prebuilt archives over plain HTTP.
+package corecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// Meta is a small, caller-defined bag of facts about what produced a
+// cached entry (FQBN, platform, compiler, optimization flags, ...). Each
+// Cache implementation persists it alongside the entry however suits its
+// storage medium.
+type Meta map[string]string
+
+// Cache is a content-addressed store for compiled core archives.
+type Cache interface {
+	// Lookup returns the cached entry for key and its Meta. found is false
+	// if there's no entry for key yet; callers must Close a non-nil r.
+	Lookup(key string) (r io.ReadCloser, meta Meta, found bool, err error)
+
+	// Store saves r's content under key, alongside the given Meta.
+	Store(key string, r io.Reader, meta Meta) error
+
+	// Stat reports whether an entry exists for key, without fetching it.
+	Stat(key string) (found bool, err error)
+}
+
+// ComputeKey derives the content-addressed cache key for a core build: a
+// sha256 over the FQBN, the resolved compiler identity, the optimization
+// flags in effect, and the path and content of every source file under
+// coreFolder. Unlike hashing coreFolder's own path, this is safe to share
+// across machines with different absolute paths, as long as the actual
+// inputs to the build are the same.
+func ComputeKey(fqbn, compilerID, optimizationFlags string, coreFolder *paths.Path) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, fqbn)
+	h.Write([]byte{0})
+	io.WriteString(h, compilerID)
+	h.Write([]byte{0})
+	io.WriteString(h, optimizationFlags)
+
+	files, err := coreFolder.ReadDirRecursive()
+	if err != nil {
+		return "", err
+	}
+	files.FilterOutDirs()
+	sort.Slice(files, func(i, j int) bool { return files[i].String() < files[j].String() })
+
+	for _, file := range files {
+		rel, err := file.RelTo(coreFolder)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+		io.WriteString(h, rel.String())
+
+		f, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,137 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package corecache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// metaHeader carries a Cache's Meta as a JSON-encoded HTTP header, since
+// the expected deployment (a plain HTTP file server or artifact bucket
+// fronting a CI fleet's shared cache) has no notion of sidecar files.
+const metaHeader = "X-Core-Cache-Meta"
+
+// HTTPCache stores entries on a remote HTTP endpoint: Lookup issues a GET
+// to BaseURL/<key>, Store a PUT, each bearer-authenticated when AuthToken
+// is set.
+type HTTPCache struct {
+	BaseURL   string
+	AuthToken string
+	Client    *http.Client
+}
+
+// NewHTTPCache creates an HTTPCache against baseURL, optionally
+// bearer-authenticating every request with authToken (empty for none).
+func NewHTTPCache(baseURL, authToken string) *HTTPCache {
+	return &HTTPCache{BaseURL: strings.TrimRight(baseURL, "/"), AuthToken: authToken}
+}
+
+func (c *HTTPCache) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+func (c *HTTPCache) url(key string) string {
+	return c.BaseURL + "/" + key
+}
+
+func (c *HTTPCache) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+	return req, nil
+}
+
+// Lookup implements Cache.
+func (c *HTTPCache) Lookup(key string) (io.ReadCloser, Meta, bool, error) {
+	req, err := c.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, false, fmt.Errorf("fetching %s: unexpected status %s", c.url(key), resp.Status)
+	}
+
+	var meta Meta
+	if raw := resp.Header.Get(metaHeader); raw != "" {
+		meta = Meta{}
+		_ = json.Unmarshal([]byte(raw), &meta)
+	}
+	return resp.Body, meta, true, nil
+}
+
+// Store implements Cache.
+func (c *HTTPCache) Store(key string, r io.Reader, meta Meta) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(http.MethodPut, key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	if len(meta) > 0 {
+		if raw, err := json.Marshal(meta); err == nil {
+			req.Header.Set(metaHeader, string(raw))
+		}
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("uploading %s: unexpected status %s", c.url(key), resp.Status)
+	}
+	return nil
+}
+
+// Stat implements Cache.
+func (c *HTTPCache) Stat(key string) (bool, error) {
+	req, err := c.newRequest(http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
@@ -0,0 +1,104 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package corecache
+
+import (
+	"encoding/json"
+	"io"
+
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// LocalCache is a Cache rooted at a directory on local disk: the
+// historical behaviour, where entries are plain files named after their
+// key, with a ".json" Meta sidecar next to each.
+type LocalCache struct {
+	Root *paths.Path
+}
+
+// NewLocalCache creates a LocalCache rooted at root.
+func NewLocalCache(root *paths.Path) *LocalCache {
+	return &LocalCache{Root: root}
+}
+
+func (c *LocalCache) pathFor(key string) (entryFile, metaFile *paths.Path) {
+	return c.Root.Join(key), c.Root.Join(key + ".json")
+}
+
+// Lookup implements Cache.
+func (c *LocalCache) Lookup(key string) (io.ReadCloser, Meta, bool, error) {
+	entryFile, metaFile := c.pathFor(key)
+	if !entryFile.Exist() {
+		return nil, nil, false, nil
+	}
+	f, err := entryFile.Open()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	meta, err := readMeta(metaFile)
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
+	}
+	return f, meta, true, nil
+}
+
+// Store implements Cache.
+func (c *LocalCache) Store(key string, r io.Reader, meta Meta) error {
+	entryFile, metaFile := c.pathFor(key)
+	if err := entryFile.Parent().MkdirAll(); err != nil {
+		return err
+	}
+	f, err := entryFile.Create()
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(f, r)
+	closeErr := f.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return metaFile.WriteFile(data)
+}
+
+// Stat implements Cache.
+func (c *LocalCache) Stat(key string) (bool, error) {
+	entryFile, _ := c.pathFor(key)
+	return entryFile.Exist(), nil
+}
+
+func readMeta(metaFile *paths.Path) (Meta, error) {
+	if !metaFile.Exist() {
+		return nil, nil
+	}
+	data, err := metaFile.ReadFile()
+	if err != nil {
+		return nil, err
+	}
+	meta := Meta{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,131 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package corecache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+)
+
+// signatureMetaKey is the Meta entry SignedCache stores the HMAC under.
+// It is excluded from the HMAC input itself (it can't sign over its own
+// value) and from the Meta a verified Lookup hands back to the caller, so
+// callers never have to know signing is happening underneath.
+const signatureMetaKey = "signature"
+
+// SignedCache wraps an underlying Cache, computing an HMAC-SHA256 (keyed
+// by Secret) over each entry's bytes plus its Meta manifest on Store, and
+// treating a Lookup hit as a miss if the signature is missing or doesn't
+// match. This closes the door on a stale or tampered core.a silently
+// poisoning a build just because it happened to land under the right key
+// - particularly important once the underlying Cache is a shared/remote
+// one (see HTTPCache) rather than a cache only the local machine writes
+// to.
+type SignedCache struct {
+	Cache  Cache
+	Secret string
+}
+
+// NewSignedCache wraps cache, signing and verifying entries with secret
+// (typically the per-installation secret already provisioned by
+// inventory.Init, so no new key management is required).
+func NewSignedCache(cache Cache, secret string) *SignedCache {
+	return &SignedCache{Cache: cache, Secret: secret}
+}
+
+// Lookup implements Cache.
+func (c *SignedCache) Lookup(key string) (io.ReadCloser, Meta, bool, error) {
+	r, meta, found, err := c.Cache.Lookup(key)
+	if err != nil || !found {
+		return r, meta, found, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	signature := meta[signatureMetaKey]
+	unsigned := withoutSignature(meta)
+	if signature == "" || !hmac.Equal([]byte(signature), []byte(c.sign(key, data, unsigned))) {
+		// Refuse the cached archive: report it as a plain miss so the
+		// caller falls back to a fresh compile instead of failing outright.
+		return nil, nil, false, nil
+	}
+	return io.NopCloser(bytes.NewReader(data)), unsigned, true, nil
+}
+
+// Store implements Cache.
+func (c *SignedCache) Store(key string, r io.Reader, meta Meta) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	signed := Meta{}
+	for k, v := range meta {
+		signed[k] = v
+	}
+	signed[signatureMetaKey] = c.sign(key, data, meta)
+	return c.Cache.Store(key, bytes.NewReader(data), signed)
+}
+
+// Stat implements Cache. It can only report whether an entry for key
+// exists, not whether its signature would verify - a signature check
+// needs the entry's bytes, which Stat, by design, never fetches.
+func (c *SignedCache) Stat(key string) (bool, error) {
+	return c.Cache.Stat(key)
+}
+
+// sign computes the HMAC-SHA256 over key, the entry bytes and the sorted
+// manifest entries of meta (platform, compiler, optimization flags, ...).
+func (c *SignedCache) sign(key string, data []byte, meta Meta) string {
+	h := hmac.New(sha256.New, []byte(c.Secret))
+	io.WriteString(h, key)
+	h.Write([]byte{0})
+	h.Write(data)
+	for _, k := range sortedMetaKeys(meta) {
+		h.Write([]byte{0})
+		io.WriteString(h, k)
+		h.Write([]byte{0})
+		io.WriteString(h, meta[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func withoutSignature(meta Meta) Meta {
+	out := Meta{}
+	for k, v := range meta {
+		if k != signatureMetaKey {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func sortedMetaKeys(meta Meta) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package diagnostics turns compiler stderr output into a stream of
+// structured Diagnostic records, so that IDEs can render inline squiggles
+// and quick-fixes without re-parsing raw text. It understands GCC's
+// `-fdiagnostics-format=json` output and falls back to the classic
+// `file:line:col: severity: message` text format on older toolchains that
+// don't support it.
+package diagnostics
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+)
+
+// Fixit represents a single suggested source replacement, as emitted by
+// GCC's JSON diagnostics under "fixits".
+type Fixit struct {
+	File        string `json:"file"`
+	StartLine   int    `json:"start_line"`
+	StartColumn int    `json:"start_column"`
+	EndLine     int     `json:"end_line"`
+	EndColumn   int     `json:"end_column"`
+	Replacement string  `json:"replacement"`
+}
+
+// Diagnostic is a single compiler message, optionally carrying suggested
+// fixes and nested notes (Children).
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Category is the compiler's warning/error class, e.g. "-Wunused-variable"
+	// or "-Wall". It is empty for diagnostics a toolchain doesn't tag with one.
+	Category string `json:"category,omitempty"`
+
+	Fixits   []Fixit      `json:"fixits,omitempty"`
+	Children []Diagnostic `json:"children,omitempty"`
+}
+
+// gccJSONDiagnostic mirrors the shape GCC emits for
+// -fdiagnostics-format=json, which is close but not identical to the
+// public Diagnostic struct (e.g. locations are nested objects).
+type gccJSONDiagnostic struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Option  string `json:"option"`
+	Locations []struct {
+		Caret struct {
+			File         string `json:"file"`
+			Line         int    `json:"line"`
+			DisplayColumn int   `json:"display-column"`
+		} `json:"caret"`
+	} `json:"locations"`
+	Fixits []struct {
+		Start struct {
+			File          string `json:"file"`
+			Line          int    `json:"line"`
+			DisplayColumn int    `json:"display-column"`
+		} `json:"start"`
+		Next struct {
+			Line          int `json:"line"`
+			DisplayColumn int `json:"display-column"`
+		} `json:"next"`
+		String string `json:"string"`
+	} `json:"fixits"`
+	Children []gccJSONDiagnostic `json:"children"`
+}
+
+func (g *gccJSONDiagnostic) toDiagnostic() Diagnostic {
+	d := Diagnostic{Severity: g.Kind, Message: g.Message, Category: g.Option}
+	if len(g.Locations) > 0 {
+		c := g.Locations[0].Caret
+		d.File = c.File
+		d.Line = c.Line
+		d.Column = c.DisplayColumn
+	}
+	for _, f := range g.Fixits {
+		d.Fixits = append(d.Fixits, Fixit{
+			File:        f.Start.File,
+			StartLine:   f.Start.Line,
+			StartColumn: f.Start.DisplayColumn,
+			EndLine:     f.Next.Line,
+			EndColumn:   f.Next.DisplayColumn,
+			Replacement: f.String,
+		})
+	}
+	for _, child := range g.Children {
+		d.Children = append(d.Children, child.toDiagnostic())
+	}
+	return d
+}
+
+// ParseJSON parses GCC's `-fdiagnostics-format=json` output, which is a
+// single JSON array of diagnostic objects (GCC emits the whole array at
+// the end of the run, it is not streamed line-by-line).
+func ParseJSON(out []byte) ([]Diagnostic, error) {
+	var raw []gccJSONDiagnostic
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, err
+	}
+	diags := make([]Diagnostic, 0, len(raw))
+	for _, r := range raw {
+		diags = append(diags, r.toDiagnostic())
+	}
+	return diags, nil
+}
+
+// classicLineRegexp matches the traditional single-line GCC/Clang
+// diagnostic format: "file.cpp:12:3: error: message text". The trailing
+// " [-Wsomething]" GCC appends to warnings is captured separately so it
+// can be surfaced as Diagnostic.Category instead of staying embedded in
+// the message text.
+var classicLineRegexp = regexp.MustCompile(`^([^:]+):(\d+):(\d+):\s+(fatal error|error|warning|note):\s+(.*?)(?:\s+\[(-W[a-zA-Z0-9-]+)\])?$`)
+
+// ParseClassic parses the traditional textual
+// "file:line:col: severity: message" format used by GCC versions that
+// don't support -fdiagnostics-format=json.
+func ParseClassic(stderr string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range splitLines(stderr) {
+		m := classicLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		lineNo, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		severity := m[4]
+		if severity == "fatal error" {
+			severity = "error"
+		}
+		diags = append(diags, Diagnostic{
+			File:     m[1],
+			Line:     lineNo,
+			Column:   col,
+			Severity: severity,
+			Message:  m[5],
+			Category: m[6],
+		})
+	}
+	return diags
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			lines = append(lines, s[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		lines = append(lines, s[start:])
+	}
+	return lines
+}
+
+// Parse tries ParseJSON first (for toolchains invoked with
+// -fdiagnostics-format=json) and falls back to ParseClassic when the
+// output isn't valid JSON.
+func Parse(out []byte) []Diagnostic {
+	if diags, err := ParseJSON(out); err == nil {
+		return diags
+	}
+	return ParseClassic(string(out))
+}
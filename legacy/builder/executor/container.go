@@ -0,0 +1,65 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// ContainerExecutor runs commands inside a Docker/Podman container built
+// from a toolchain image, bind-mounting the build tree so the compiler
+// sees the same paths it would locally. This lets a thin client build for
+// an FQBN without having the matching AVR/ARM toolchain installed on the
+// host.
+type ContainerExecutor struct {
+	// Engine is the container CLI to invoke, e.g. "docker" or "podman".
+	Engine string
+	// Image is the toolchain image to run the command in.
+	Image string
+	// BindMounts lists "host:container" bind-mount specs; the build
+	// directory must be among them so inputs/outputs are visible both
+	// inside and outside the container.
+	BindMounts []string
+}
+
+// Run implements Executor by shelling out to the container engine.
+func (c *ContainerExecutor) Run(spec Spec) ([]byte, []byte, error) {
+	engine := c.Engine
+	if engine == "" {
+		engine = "docker"
+	}
+
+	args := []string{"run", "--rm"}
+	for _, mount := range c.BindMounts {
+		args = append(args, "-v", mount)
+	}
+	if spec.Dir != "" {
+		args = append(args, "-w", spec.Dir)
+	}
+	args = append(args, c.Image, spec.Path)
+	args = append(args, spec.Args...)
+
+	cmd := exec.Command(engine, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("running %s in container %s: %w", spec.Path, c.Image, err)
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
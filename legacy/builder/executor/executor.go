@@ -0,0 +1,38 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package executor abstracts away how a build recipe's command line is
+// actually run, so that toolchains can be invoked locally, inside a
+// container, or dispatched to a remote build farm without touching the
+// recipe-preparation logic in builder_utils.
+package executor
+
+// Spec describes a single command invocation: the resolved executable
+// path, its arguments (not including argv[0]) and the working directory
+// it should run in. It is a toolchain-agnostic stand-in for exec.Cmd, so
+// that Executor implementations don't need to depend on os/exec.
+type Spec struct {
+	Path string
+	Args []string
+	Dir  string
+}
+
+// Executor runs a single command Spec and returns its captured
+// stdout/stderr along with any execution error (non-zero exit status is
+// reported the same way os/exec does, via *exec.ExitError-compatible
+// errors for local execution).
+type Executor interface {
+	Run(spec Spec) (stdout, stderr []byte, err error)
+}
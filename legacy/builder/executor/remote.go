@@ -0,0 +1,38 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package executor
+
+import "context"
+
+// BuildFarmClient is the subset of the generated gRPC client used to
+// dispatch a single compile job to a remote build farm node. It is kept
+// as a narrow interface so RemoteExecutor can be tested without a real
+// gRPC connection, à la distcc/icecc.
+type BuildFarmClient interface {
+	Compile(ctx context.Context, path string, args []string, dir string) (stdout, stderr []byte, err error)
+}
+
+// RemoteExecutor dispatches commands over gRPC to a build farm, allowing
+// compile jobs to fan out across multiple machines (Context.Jobs still
+// governs how many are in flight locally at once).
+type RemoteExecutor struct {
+	Client BuildFarmClient
+}
+
+// Run implements Executor.
+func (r *RemoteExecutor) Run(spec Spec) ([]byte, []byte, error) {
+	return r.Client.Compile(context.Background(), spec.Path, spec.Args, spec.Dir)
+}
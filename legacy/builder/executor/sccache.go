@@ -0,0 +1,53 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// SccacheExecutor wraps each command with sccache
+// (https://github.com/mozilla/sccache), a ccache-alike that can back its
+// object-file cache with S3/GCS/Redis instead of local disk, so a compile
+// cache hit can be shared across a team or CI fleet. Unlike RemoteExecutor,
+// no build-farm dispatch protocol is involved: the compiler still runs on
+// this machine, sccache just short-circuits it on a cache hit.
+type SccacheExecutor struct {
+	// Path is the sccache binary to invoke; "sccache" (resolved via PATH)
+	// if empty.
+	Path string
+}
+
+// Run implements Executor by prepending sccache to the command line.
+func (s *SccacheExecutor) Run(spec Spec) ([]byte, []byte, error) {
+	wrapper := s.Path
+	if wrapper == "" {
+		wrapper = "sccache"
+	}
+
+	args := append([]string{spec.Path}, spec.Args...)
+	cmd := exec.Command(wrapper, args...)
+	cmd.Dir = spec.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("running %s through sccache: %w", spec.Path, err)
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package executor
+
+import (
+	"fmt"
+
+	"github.com/arduino/arduino-cli/configuration"
+)
+
+// NewFromSettings builds the Executor selected by the
+// "builder.executor.type" configuration key:
+//   - "" or "local" (the default) - nil, so the caller leaves ctx.Executor
+//     unset and utils.ExecCommand falls back to its built-in os/exec runner.
+//   - "sccache" - a SccacheExecutor wrapping "builder.executor.sccache_path"
+//     (or the "sccache" on PATH, if unset).
+//   - "grpc" - dispatch to a remote build farm at "builder.executor.endpoint"
+//     via RemoteExecutor. Building the gRPC connection itself needs a
+//     generated BuildFarmClient stub this snapshot doesn't include, so this
+//     currently returns a descriptive error instead of a half-working
+//     Executor; callers that have such a client can still construct a
+//     RemoteExecutor directly and set it on ctx.Executor themselves.
+func NewFromSettings() (Executor, error) {
+	switch typ := configuration.Settings.GetString("builder.executor.type"); typ {
+	case "", "local":
+		return nil, nil
+	case "sccache":
+		return &SccacheExecutor{Path: configuration.Settings.GetString("builder.executor.sccache_path")}, nil
+	case "grpc":
+		endpoint := configuration.Settings.GetString("builder.executor.endpoint")
+		return nil, fmt.Errorf("builder.executor.type \"grpc\" needs a BuildFarmClient dialed to %q, which this build doesn't generate yet; set builder.executor.type to \"local\" or \"sccache\", or assign a RemoteExecutor to ctx.Executor directly", endpoint)
+	default:
+		return nil, fmt.Errorf("unknown builder.executor.type %q", typ)
+	}
+}
@@ -0,0 +1,98 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"encoding/json"
+	"strings"
+
+	bldr "github.com/arduino/arduino-cli/arduino/builder"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	"github.com/arduino/arduino-cli/legacy/builder/utils"
+	"github.com/pkg/errors"
+)
+
+// ExportCompileCommands writes ctx.CompilationDatabase out as a
+// compile_commands.json compilation database, the format understood by
+// clangd, CDT and most other C/C++ tooling, into ctx.ExportCompileCommandsPath.
+// It is a no-op unless ExportCompileCommandsPath has been set, and should
+// run once the build has finished populating the database.
+//
+// The merged sketch .cpp file gets a single captured invocation even though
+// it is the concatenation of all the sketch's .ino/.cpp files, stitched
+// together with "#line N \"original/path\"" markers by FilterSketchSource.
+// That single entry is expanded here into one entry per original file the
+// markers reference, all sharing the same arguments (and therefore the same
+// include paths and defines), so that an IDE resolves the user's own sketch
+// files rather than the generated one.
+type ExportCompileCommands struct{}
+
+func (s *ExportCompileCommands) Run(ctx *types.Context) error {
+	if ctx.ExportCompileCommandsPath == nil || ctx.CompilationDatabase == nil {
+		return nil
+	}
+
+	sketchCpp := ctx.SketchBuildPath.Join(ctx.Sketch.MainFile.Name.Base() + ".cpp").String()
+
+	var entries []bldr.Command
+	for _, entry := range ctx.CompilationDatabase.Contents {
+		if entry.File != sketchCpp {
+			entries = append(entries, entry)
+			continue
+		}
+		for _, sketchFile := range sketchSourceFiles(ctx.Source) {
+			remapped := entry
+			remapped.File = sketchFile
+			entries = append(entries, remapped)
+		}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := ctx.ExportCompileCommandsPath.MkdirAll(); err != nil {
+		return errors.WithStack(err)
+	}
+	return ctx.ExportCompileCommandsPath.Join("compile_commands.json").WriteFile(data)
+}
+
+// sketchSourceFiles returns, in order of first appearance, the distinct
+// paths referenced by the "#line N \"path\"" markers FilterSketchSource
+// stitches into the merged sketch source: the original .ino/.cpp files that
+// make up the sketch.
+func sketchSourceFiles(source string) []string {
+	seen := map[string]bool{}
+	var files []string
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#line ") {
+			continue
+		}
+		fields := strings.SplitN(line[len("#line "):], " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		file, _, ok := utils.ParseCppString(fields[1])
+		if !ok || seen[file] {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	return files
+}
@@ -0,0 +1,146 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/arduino/arduino-cli/arduino/libraries"
+	"github.com/arduino/go-paths-helper"
+)
+
+// IncludeGraphLibrary is a library's identity and why it was (or wasn't)
+// chosen to satisfy a given #include, as recorded in an IncludeGraphEntry.
+type IncludeGraphLibrary struct {
+	Name              string `json:"name"`
+	Version           string `json:"version,omitempty"`
+	InstallPath       string `json:"install_path,omitempty"`
+	Architecture      string `json:"architecture"`
+	ArchitectureScore int    `json:"architecture_score"`
+}
+
+// IncludeGraphEntry records, for a single #include found while walking
+// SourceFile, the library ResolveLibrary chose to satisfy it and every
+// other installed library that also provided a matching header but lost
+// out, each scored by the same architecture specificity ResolveLibrary
+// itself uses to pick between them.
+type IncludeGraphEntry struct {
+	SourceFile           string                 `json:"source_file"`
+	Include              string                 `json:"include"`
+	ResolvedLibrary      *IncludeGraphLibrary   `json:"resolved_library"`
+	RejectedAlternatives []*IncludeGraphLibrary `json:"rejected_alternatives,omitempty"`
+}
+
+// includeGraph accumulates IncludeGraphEntry values across the concurrent
+// findIncludesUntilDone goroutines findIncludesParallel fans out, guarded
+// by its own mutex (mirroring includeCache and includeHashCache above).
+// It's only built when ctx.DumpIncludeGraph is set; a nil *includeGraph
+// means "not collecting" and every method below is a safe no-op on it.
+type includeGraph struct {
+	mutex   sync.Mutex
+	Entries []*IncludeGraphEntry `json:"entries"`
+}
+
+// newIncludeGraph returns an empty includeGraph ready to record entries.
+func newIncludeGraph() *includeGraph {
+	return &includeGraph{}
+}
+
+// record adds an entry for a resolved #include. It's a no-op when g is
+// nil, so call sites don't need to guard every call on ctx.DumpIncludeGraph
+// themselves.
+func (g *includeGraph) record(sourcePath *paths.Path, include string, architecture string, resolved *libraries.Library, rejected libraries.List) {
+	if g == nil {
+		return
+	}
+
+	var alternatives []*IncludeGraphLibrary
+	for _, lib := range rejected {
+		if lib == resolved {
+			continue
+		}
+		alternatives = append(alternatives, toIncludeGraphLibrary(lib, architecture))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.Entries = append(g.Entries, &IncludeGraphEntry{
+		SourceFile:           sourcePath.String(),
+		Include:              include,
+		ResolvedLibrary:      toIncludeGraphLibrary(resolved, architecture),
+		RejectedAlternatives: alternatives,
+	})
+}
+
+// WriteTo marshals g as indented JSON to path. Called unconditionally by
+// ContainerFindIncludes.Run when ctx.DumpIncludeGraph is set, even if no
+// entry was ever recorded (an empty sketch still gets a valid, empty
+// graph rather than a stale or missing file).
+func (g *includeGraph) WriteTo(path *paths.Path) error {
+	if g == nil {
+		return nil
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return path.WriteFile(data)
+}
+
+// toIncludeGraphLibrary converts lib to its graph representation, scored
+// for how specifically it targets architecture. Returns nil for a nil
+// library, so an entry with no resolved library (not expected in
+// practice, since findIncludesUntilDone only records a graph entry once
+// ResolveLibrary has succeeded) serializes as a JSON null rather than a
+// zero-value struct.
+func toIncludeGraphLibrary(lib *libraries.Library, architecture string) *IncludeGraphLibrary {
+	if lib == nil {
+		return nil
+	}
+	entry := &IncludeGraphLibrary{
+		Name:              lib.Name,
+		Architecture:      architecture,
+		ArchitectureScore: architectureScore(lib, architecture),
+	}
+	if lib.Version != nil {
+		entry.Version = lib.Version.String()
+	}
+	if lib.InstallDir != nil {
+		entry.InstallPath = lib.InstallDir.String()
+	}
+	return entry
+}
+
+// architectureScore mirrors the specificity ranking librariesresolver.Cpp
+// uses to prefer one compatible library over another: a library that
+// lists architecture explicitly is a closer match than one that only
+// declares itself architecture-independent via the "*" wildcard.
+func architectureScore(lib *libraries.Library, architecture string) int {
+	for _, arch := range lib.Architectures {
+		if arch == architecture {
+			return 2
+		}
+	}
+	for _, arch := range lib.Architectures {
+		if arch == "*" {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package includes finds the library an unresolved #include should pull
+// in, much like container_find_includes.go's gcc-preprocessor-plus-regexp
+// approach, but through pluggable types.IncludeResolver implementations.
+// The legacy gcc pipeline remains the default; this package lets it be
+// swapped for a real C++ frontend (clang-scan-deps) that names the first
+// missing header directly in its diagnostics, instead of having to grep
+// it back out of gcc's "file not found" error text.
+package includes
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// ScanDepsResolver finds missing includes by running clang-scan-deps
+// over the source file in dependency-scan mode: it walks every #include
+// it can resolve and, on the first one it can't, reports a "file not
+// found" diagnostic naming it directly, so no regexp is needed to pull
+// the filename back out.
+type ScanDepsResolver struct {
+	// ClangScanDepsPath is the path to the clang-scan-deps binary to run.
+	// Defaults to "clang-scan-deps" on the PATH when empty.
+	ClangScanDepsPath string
+}
+
+// NewScanDepsResolver creates a ScanDepsResolver that runs
+// "clang-scan-deps" from the PATH.
+func NewScanDepsResolver() *ScanDepsResolver {
+	return &ScanDepsResolver{ClangScanDepsPath: "clang-scan-deps"}
+}
+
+// FindMissingInclude implements types.IncludeResolver.
+func (r *ScanDepsResolver) FindMissingInclude(sourcePath *paths.Path, includeFolders paths.PathList) (string, error) {
+	path := r.ClangScanDepsPath
+	if path == "" {
+		path = "clang-scan-deps"
+	}
+
+	args := []string{"-format=make", "--"}
+	for _, folder := range includeFolders {
+		args = append(args, "-I"+folder.String())
+	}
+	args = append(args, sourcePath.String())
+
+	cmd := exec.Command(path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if include := missingIncludeFromDiagnostics(stderr.String()); include != "" {
+		return include, nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, stderr.String())
+	}
+	return "", nil
+}
+
+// missingIncludeFromDiagnostics scans a clang-scan-deps/clang diagnostic
+// stream for the first "file not found" error, e.g.:
+//
+//	Sketch.ino:3:10: fatal error: 'Foo.h' file not found
+//
+// and returns the quoted header name, or "" if none is present.
+func missingIncludeFromDiagnostics(stderr string) string {
+	const marker = "file not found"
+	for _, line := range strings.Split(stderr, "\n") {
+		if !strings.Contains(line, marker) {
+			continue
+		}
+		start := strings.Index(line, "'")
+		if start == -1 {
+			continue
+		}
+		end := strings.Index(line[start+1:], "'")
+		if end == -1 {
+			continue
+		}
+		return line[start+1 : start+1+end]
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type positionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	} `json:"position"`
+}
+
+func (s *Server) handleDidChange(w io.Writer, params json.RawMessage) {
+	var p didChangeParams
+	if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+		return
+	}
+	text := p.ContentChanges[len(p.ContentChanges)-1].Text
+
+	s.mu.Lock()
+	s.docs[p.TextDocument.URI] = text
+	if s.Ctx.SourceOverride == nil {
+		s.Ctx.SourceOverride = map[string]string{}
+	}
+	s.Ctx.SourceOverride[relativeToSketch(s.Ctx, p.TextDocument.URI)] = text
+	s.mu.Unlock()
+
+	if s.Rebuild == nil {
+		return
+	}
+	diags, err := s.Rebuild(s.Ctx)
+	if err != nil {
+		// Even a failed rebuild may have produced diagnostics (e.g. a
+		// syntax error); fall through and publish whatever we have.
+		_ = err
+	}
+	s.publishDiagnostics(w, p.TextDocument.URI, diags)
+}
+
+// handleCompletion answers from Context.CodeModelBuilder's known headers
+// and the last set of extracted prototypes, which is the same data the
+// ctags-based preprocessor would use to satisfy
+// Context.CodeCompleteAt/CodeCompletions.
+func (s *Server) handleCompletion(w io.Writer, id json.RawMessage, params json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := []map[string]interface{}{}
+	cm := s.Ctx.CodeModelBuilder
+	if cm != nil {
+		for _, proto := range cm.Prototypes {
+			items = append(items, map[string]interface{}{
+				"label": proto.FunctionName,
+				"kind":  3, // Function
+			})
+		}
+		for _, h := range cm.KnownHeaders {
+			items = append(items, map[string]interface{}{
+				"label": h.Name,
+				"kind":  17, // File
+			})
+		}
+	}
+	s.reply(w, id, map[string]interface{}{"isIncomplete": false, "items": items})
+}
+
+func (s *Server) handleHover(w io.Writer, id json.RawMessage, params json.RawMessage) {
+	var p positionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		s.replyError(w, id, -32602, "invalid hover params")
+		return
+	}
+
+	s.mu.Lock()
+	text := s.docs[p.TextDocument.URI]
+	s.mu.Unlock()
+
+	word := wordAt(text, p.Position.Line, p.Position.Character)
+	if word == "" {
+		s.reply(w, id, nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cm := s.Ctx.CodeModelBuilder
+	if cm == nil {
+		s.reply(w, id, nil)
+		return
+	}
+	for _, proto := range cm.Prototypes {
+		if proto.FunctionName == word {
+			s.reply(w, id, map[string]interface{}{
+				"contents": proto.Prototype,
+			})
+			return
+		}
+	}
+	s.reply(w, id, nil)
+}
+
+func (s *Server) handleDefinition(w io.Writer, id json.RawMessage, params json.RawMessage) {
+	var p positionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		s.replyError(w, id, -32602, "invalid definition params")
+		return
+	}
+
+	s.mu.Lock()
+	text := s.docs[p.TextDocument.URI]
+	s.mu.Unlock()
+
+	word := wordAt(text, p.Position.Line, p.Position.Character)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cm := s.Ctx.CodeModelBuilder
+	if cm == nil || word == "" {
+		s.reply(w, id, nil)
+		return
+	}
+	for _, proto := range cm.Prototypes {
+		if proto.FunctionName == word {
+			s.reply(w, id, map[string]interface{}{
+				"uri": p.TextDocument.URI,
+				"range": map[string]interface{}{
+					"start": map[string]int{"line": proto.Line, "character": 0},
+					"end":   map[string]int{"line": proto.Line, "character": 0},
+				},
+			})
+			return
+		}
+	}
+	s.reply(w, id, nil)
+}
+
+// wordAt extracts the identifier under the given zero-based line/column
+// in text, using a simple scan since the code model doesn't carry a full
+// token stream.
+func wordAt(text string, line, character int) string {
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	l := lines[line]
+	if character < 0 || character > len(l) {
+		return ""
+	}
+	isIdent := func(c byte) bool {
+		return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	start := character
+	for start > 0 && isIdent(l[start-1]) {
+		start--
+	}
+	end := character
+	for end < len(l) && isIdent(l[end]) {
+		end++
+	}
+	return l[start:end]
+}
@@ -0,0 +1,163 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/arduino/arduino-cli/legacy/builder/diagnostics"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// Rebuilder drives one incremental preprocess+ctags pass over ctx after a
+// didChange notification has updated ctx.SourceOverride, and reports any
+// compiler diagnostics it collected along the way. Callers typically
+// implement this by running the same command pipeline used by
+// ContainerAddPrototypes, with ctx.DiagnosticSink wired up to a channel
+// that feeds diagnostics back here.
+type Rebuilder func(ctx *types.Context) ([]diagnostics.Diagnostic, error)
+
+// Server is a minimal LSP server backed by the legacy builder's code
+// model (Context.CodeModelBuilder, Context.CodeCompleteAt and
+// Context.CodeCompletions). It speaks JSON-RPC 2.0 framed messages over
+// stdio or TCP.
+type Server struct {
+	Ctx      *types.Context
+	Rebuild  Rebuilder
+
+	mu   sync.Mutex
+	docs map[string]string // URI -> last known full text
+}
+
+// NewServer creates a Server driving ctx's code model, using rebuild to
+// refresh it whenever a document changes.
+func NewServer(ctx *types.Context, rebuild Rebuilder) *Server {
+	return &Server{Ctx: ctx, Rebuild: rebuild, docs: map[string]string{}}
+}
+
+// Serve runs the LSP message loop over rw until the connection is closed
+// or a fatal transport error occurs.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	r := bufio.NewReader(rw)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			continue
+		}
+
+		s.dispatch(rw, &req)
+	}
+}
+
+func (s *Server) dispatch(w io.Writer, req *request) {
+	switch req.Method {
+	case "initialize":
+		s.reply(w, req.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full-document sync
+				"completionProvider": map[string]interface{}{},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+			},
+		})
+	case "textDocument/didChange":
+		s.handleDidChange(w, req.Params)
+	case "textDocument/completion":
+		s.handleCompletion(w, req.ID, req.Params)
+	case "textDocument/hover":
+		s.handleHover(w, req.ID, req.Params)
+	case "textDocument/definition":
+		s.handleDefinition(w, req.ID, req.Params)
+	default:
+		if len(req.ID) > 0 {
+			s.replyError(w, req.ID, -32601, "method not found: "+req.Method)
+		}
+	}
+}
+
+func (s *Server) reply(w io.Writer, id json.RawMessage, result interface{}) {
+	if len(id) == 0 {
+		return
+	}
+	writeMessage(w, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(w io.Writer, id json.RawMessage, code int, message string) {
+	writeMessage(w, response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+func (s *Server) publishDiagnostics(w io.Writer, uri string, diags []diagnostics.Diagnostic) {
+	items := make([]map[string]interface{}, 0, len(diags))
+	for _, d := range diags {
+		severity := 1 // Error
+		switch d.Severity {
+		case "warning":
+			severity = 2
+		case "note":
+			severity = 3
+		}
+		items = append(items, map[string]interface{}{
+			"range": map[string]interface{}{
+				"start": map[string]int{"line": max0(d.Line - 1), "character": max0(d.Column - 1)},
+				"end":   map[string]int{"line": max0(d.Line - 1), "character": max0(d.Column - 1)},
+			},
+			"severity": severity,
+			"message":  d.Message,
+		})
+	}
+	writeMessage(w, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": items,
+		},
+	})
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// relativeToSketch strips a file:// URI down to the path arduino-cli's
+// SourceOverride map expects (relative to the sketch folder).
+func relativeToSketch(ctx *types.Context, uri string) string {
+	path := strings.TrimPrefix(uri, "file://")
+	if ctx.SketchLocation == nil {
+		return path
+	}
+	rel, err := ctx.SketchLocation.Parent().RelTo(paths.New(path))
+	if err != nil {
+		return path
+	}
+	return rel.String()
+}
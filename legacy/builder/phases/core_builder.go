@@ -16,11 +16,18 @@
 package phases
 
 import (
+	"encoding/json"
+	"io"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/arduino/arduino-cli/inventory"
 	"github.com/arduino/arduino-cli/legacy/builder/builder_utils"
 	"github.com/arduino/arduino-cli/legacy/builder/constants"
+	"github.com/arduino/arduino-cli/legacy/builder/corecache"
+	"github.com/arduino/arduino-cli/legacy/builder/executor"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	"github.com/arduino/arduino-cli/legacy/builder/utils"
 	"github.com/arduino/go-paths-helper"
@@ -35,6 +42,17 @@ func (s *CoreBuilder) Run(ctx *types.Context) error {
 	coreBuildCachePath := ctx.CoreBuildCachePath
 	var buildProperties = ctx.BuildProperties
 
+	if ctx.Executor == nil {
+		// A caller (e.g. a test, or a command wiring up a container/remote
+		// build already) may have set ctx.Executor explicitly; only fall
+		// back to configuration when it's still unset.
+		exec, err := executor.NewFromSettings()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		ctx.Executor = exec
+	}
+
 	if err := coreBuildPath.MkdirAll(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -60,10 +78,15 @@ func (s *CoreBuilder) Run(ctx *types.Context) error {
 	if ctx.UnoptimizeCore {
 		buildProperties = builder_utils.RemoveOptimizationFromBuildProperties(buildProperties)
 	}
-	
+
 	buildProperties = builder_utils.ExpandSysprogsExtensionProperties(buildProperties, "core")
-	
-	archiveFile, objectFiles, err := compileCore(ctx, coreBuildPath, coreBuildCachePath, buildProperties, coreModel)
+
+	lockedPlatformRevision, err := checkLockfile(ctx)
+	if err != nil {
+		return err
+	}
+
+	archiveFile, objectFiles, err := compileCore(ctx, coreBuildPath, coreBuildCachePath, buildProperties, coreModel, lockedPlatformRevision)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -71,16 +94,134 @@ func (s *CoreBuilder) Run(ctx *types.Context) error {
 	ctx.CoreArchiveFilePath = archiveFile
 	ctx.CoreObjectsFiles = objectFiles
 
+	if ctx.UpdateLock {
+		if err := updateLockfile(ctx, archiveFile.Base()); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	return nil
 }
 
-func compileCore(ctx *types.Context, buildPath *paths.Path, buildCachePath *paths.Path, buildProperties *properties.Map, coreModel *types.CodeModelLibrary) (*paths.Path, paths.PathList, error) {
+// checkLockfile consults the sketch's arduino-lock.yaml (if any) against
+// ctx.ActualPlatform and ctx.RequiredTools: if a locked revision isn't the
+// one currently installed, the build fails fast instead of silently
+// compiling against whatever happens to be on disk. It is skipped entirely
+// when ctx.UpdateLock is set, since that build is about to replace the lock
+// rather than honor it. It returns the locked platform version (the empty
+// string if the sketch isn't locked, or UpdateLock is set), which feeds
+// GetCachedCoreArchiveFileName's cache key so an unlocked platform upgrade
+// can't be served a core archive cached under the old, still-locked
+// revision.
+//
+// Nothing in this tree sets ctx.UpdateLock yet: that needs a `--update-lock`
+// flag on the `compile` command, and `cli/compile` doesn't exist as files
+// here. Until it's wired up, a locked sketch can never be relocked through
+// arduino-cli itself.
+func checkLockfile(ctx *types.Context) (string, error) {
+	if ctx.SketchLocation == nil || ctx.UpdateLock {
+		return "", nil
+	}
+
+	lf, err := inventory.OpenLockfile(ctx.SketchLocation.Parent().String())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !lf.Locked() {
+		return "", nil
+	}
+
+	lockedPlatform := lf.Platform()
+	if ctx.ActualPlatform != nil && lockedPlatform.Name != "" {
+		if lockedPlatform.Name != ctx.ActualPlatform.String() || lockedPlatform.Version != ctx.ActualPlatform.Version.String() {
+			return "", errors.Errorf("sketch is locked to platform %s@%s, but %s@%s is selected; relock by building with ctx.UpdateLock set",
+				lockedPlatform.Name, lockedPlatform.Version, ctx.ActualPlatform, ctx.ActualPlatform.Version)
+		}
+	}
+
+	installedToolVersions := map[string]string{}
+	for _, tool := range ctx.RequiredTools {
+		installedToolVersions[tool.String()] = tool.Version.String()
+	}
+	for _, lockedTool := range lf.Tools() {
+		installedVersion, ok := installedToolVersions[lockedTool.Name]
+		if !ok {
+			return "", errors.Errorf("sketch is locked to tool %s@%s, which is not currently installed; relock by building with ctx.UpdateLock set",
+				lockedTool.Name, lockedTool.Version)
+		}
+		if installedVersion != lockedTool.Version {
+			return "", errors.Errorf("sketch is locked to tool %s@%s, but %s@%s is installed; relock by building with ctx.UpdateLock set",
+				lockedTool.Name, lockedTool.Version, lockedTool.Name, installedVersion)
+		}
+	}
+
+	return lockedPlatform.Version, nil
+}
+
+// updateLockfile overwrites the sketch's arduino-lock.yaml with the
+// platform, tools, referenced cores, libraries and FQBN currently resolved
+// in ctx, plus the core archive archiveFileName was just cached under.
+func updateLockfile(ctx *types.Context, archiveFileName string) error {
+	lf, err := inventory.OpenLockfile(ctx.SketchLocation.Parent().String())
+	if err != nil {
+		return err
+	}
+
+	var platform inventory.LockedComponent
+	if ctx.ActualPlatform != nil {
+		platform = inventory.LockedComponent{Name: ctx.ActualPlatform.String(), Version: ctx.ActualPlatform.Version.String()}
+	}
+
+	var tools []inventory.LockedComponent
+	for _, tool := range ctx.RequiredTools {
+		tools = append(tools, inventory.LockedComponent{
+			Name:        tool.String(),
+			Version:     tool.Version.String(),
+			InstallPath: pathOrEmpty(tool.InstallDir),
+		})
+	}
+
+	var referencedCores []inventory.LockedComponent
+	if ctx.TargetPlatform != nil && ctx.TargetPlatform != ctx.ActualPlatform {
+		referencedCores = append(referencedCores, inventory.LockedComponent{
+			Name:    ctx.TargetPlatform.String(),
+			Version: ctx.TargetPlatform.Version.String(),
+		})
+	}
+
+	var libs []inventory.LockedComponent
+	for _, lib := range ctx.ImportedLibraries {
+		version := ""
+		if lib.Version != nil {
+			version = lib.Version.String()
+		}
+		libs = append(libs, inventory.LockedComponent{
+			Name:        lib.Name,
+			Version:     version,
+			InstallPath: pathOrEmpty(lib.InstallDir),
+		})
+	}
+
+	fqbn := ""
+	if ctx.FQBN != nil {
+		fqbn = ctx.FQBN.String()
+	}
+
+	return lf.Update(platform, tools, referencedCores, libs, fqbn, archiveFileName)
+}
+
+func pathOrEmpty(p *paths.Path) string {
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
+
+func compileCore(ctx *types.Context, buildPath *paths.Path, buildCachePath *paths.Path, buildProperties *properties.Map, coreModel *types.CodeModelLibrary, lockedPlatformRevision string) (*paths.Path, paths.PathList, error) {
 	logger := ctx.GetLogger()
 	coreFolder := buildProperties.GetPath("build.core.path")
 	variantFolder := buildProperties.GetPath("build.variant.path")
 
-	targetCoreFolder := buildProperties.GetPath(constants.BUILD_PROPERTIES_RUNTIME_PLATFORM_PATH)
-	
 	if coreModel != nil {
 		coreModel.SourceDirectory = coreFolder.String()
 		coreModel.Name = buildProperties.Get("name")
@@ -107,20 +248,39 @@ func compileCore(ctx *types.Context, buildPath *paths.Path, buildCachePath *path
 	realCoreFolder := coreFolder.Parent().Parent()
 
 	var targetArchivedCore *paths.Path
+	var cache corecache.Cache
+	var archiveKey string
 	if buildCachePath != nil {
-		archivedCoreName := GetCachedCoreArchiveFileName(buildProperties.Get(constants.BUILD_PROPERTIES_FQBN),
-			buildProperties.Get("compiler.optimization_flags"), realCoreFolder)
-		targetArchivedCore = buildCachePath.Join(archivedCoreName)
-		canUseArchivedCore := !ctx.OnlyUpdateCompilationDatabase &&
-			!ctx.Clean &&
-			!builder_utils.CoreOrReferencedCoreHasChanged(realCoreFolder, targetCoreFolder, targetArchivedCore)
-
-		if canUseArchivedCore {
-			// use archived core
-			if ctx.Verbose {
-				logger.Println(constants.LOG_LEVEL_INFO, "Using precompiled core: {0}", targetArchivedCore)
+		cache = newCoreArchiveCache(buildCachePath)
+		compilerID := buildProperties.Get("compiler.path") + "@" + buildProperties.Get("compiler.version") + "@" + lockedPlatformRevision
+		archiveKey, err = corecache.ComputeKey(buildProperties.Get(constants.BUILD_PROPERTIES_FQBN),
+			compilerID, buildProperties.Get("compiler.optimization_flags"), realCoreFolder)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		targetArchivedCore = buildCachePath.Join(GetCachedCoreArchiveFileName(buildProperties.Get(constants.BUILD_PROPERTIES_FQBN), archiveKey))
+
+		if !ctx.OnlyUpdateCompilationDatabase && !ctx.Clean {
+			if r, _, found, err := cache.Lookup(archiveKey); err != nil {
+				if ctx.Verbose {
+					logger.Println(constants.LOG_LEVEL_INFO, "Couldn't look up core cache: {0}", err)
+				}
+			} else if found {
+				defer r.Close()
+				data, err := io.ReadAll(r)
+				if err == nil {
+					err = targetArchivedCore.WriteFile(data)
+				}
+				if err == nil {
+					if ctx.Verbose {
+						logger.Println(constants.LOG_LEVEL_INFO, "Using precompiled core: {0}", targetArchivedCore)
+					}
+					return targetArchivedCore, variantObjectFiles, nil
+				}
+				if ctx.Verbose {
+					logger.Println(constants.LOG_LEVEL_INFO, "Couldn't materialize cached core: {0}", err)
+				}
 			}
-			return targetArchivedCore, variantObjectFiles, nil
 		}
 	}
 
@@ -136,7 +296,7 @@ func compileCore(ctx *types.Context, buildPath *paths.Path, buildCachePath *path
 
 	// archive core.a
 	if targetArchivedCore != nil && !ctx.OnlyUpdateCompilationDatabase && coreModel != nil {
-		err := archiveFile.CopyTo(targetArchivedCore)
+		err := storeCachedArchive(cache, archiveKey, archiveFile, targetArchivedCore, buildProperties, realCoreFolder)
 		if ctx.Verbose {
 			if err == nil {
 				logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_ARCHIVING_CORE_CACHE, targetArchivedCore)
@@ -146,24 +306,136 @@ func compileCore(ctx *types.Context, buildPath *paths.Path, buildCachePath *path
 				logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_ERROR_ARCHIVING_CORE_CACHE, targetArchivedCore, err)
 			}
 		}
+		if err == nil {
+			if metaErr := writeCoreArchiveMetadata(targetArchivedCore, buildProperties, realCoreFolder); metaErr != nil && ctx.Verbose {
+				logger.Println(constants.LOG_LEVEL_INFO, "Couldn't write core cache metadata: {0}", metaErr)
+			}
+		}
 	}
 
 	return archiveFile, variantObjectFiles, nil
 }
 
-// GetCachedCoreArchiveFileName returns the filename to be used to store
-// the global cached core.a.
-func GetCachedCoreArchiveFileName(fqbn string, optimizationFlags string, coreFolder *paths.Path) string {
+// newCoreArchiveCache builds the corecache.Cache backend configured via
+// build_cache.remote_url/build_cache.remote_auth, falling back to a
+// LocalCache rooted at localPath when no remote is configured. The result
+// is wrapped in a corecache.SignedCache keyed off the per-installation
+// secret inventory already provisions, so a stale or tampered archive -
+// local or, once a remote backend is in play, someone else's upload -
+// can't silently poison a build just because it landed under the right
+// key.
+func newCoreArchiveCache(localPath *paths.Path) corecache.Cache {
+	var backend corecache.Cache
+	if remoteURL := configuration.Settings.GetString("build_cache.remote_url"); remoteURL != "" {
+		backend = corecache.NewHTTPCache(remoteURL, configuration.Settings.GetString("build_cache.remote_auth"))
+	} else {
+		backend = corecache.NewLocalCache(localPath)
+	}
+
+	if secret := inventory.Store.GetString("installation.secret"); secret != "" {
+		return corecache.NewSignedCache(backend, secret)
+	}
+	return backend
+}
+
+// storeCachedArchive uploads archiveFile into cache under key, then
+// copies it to targetArchivedCore so the rest of the build can keep
+// treating the cached core as a plain local file (for a LocalCache this
+// is a second copy, separate from the cache's own entry).
+func storeCachedArchive(cache corecache.Cache, key string, archiveFile, targetArchivedCore *paths.Path, buildProperties *properties.Map, realCoreFolder *paths.Path) error {
+	f, err := archiveFile.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Together with key (itself a hash of the FQBN, compiler identity,
+	// optimization flags and every core source file), this is the
+	// manifest a corecache.SignedCache signs and later verifies against -
+	// so a cache hit is refused unless the compiler, platform and source
+	// tree it names still match what's being built now.
+	meta := corecache.Meta{
+		"fqbn":               buildProperties.Get(constants.BUILD_PROPERTIES_FQBN),
+		"platform":           buildProperties.Get("name"),
+		"platform_version":   buildProperties.Get("version"),
+		"compiler":           buildProperties.Get("compiler.path"),
+		"compiler_version":   buildProperties.Get("compiler.version"),
+		"optimization_flags": buildProperties.Get("compiler.optimization_flags"),
+	}
+	if err := cache.Store(key, f, meta); err != nil {
+		return err
+	}
+
+	return archiveFile.CopyTo(targetArchivedCore)
+}
+
+// GetCachedCoreArchiveFileName returns the filename to be used to store a
+// cached core.a: archiveKey is the corecache.ComputeKey content hash
+// covering the FQBN, the resolved compiler, the optimization flags and
+// every source file under realCoreFolder, so it stays valid across
+// machines with different absolute paths.
+func GetCachedCoreArchiveFileName(fqbn string, archiveKey string) string {
 	fqbnToUnderscore := strings.Replace(fqbn, ":", "_", -1)
 	fqbnToUnderscore = strings.Replace(fqbnToUnderscore, "=", "_", -1)
-	if absCoreFolder, err := coreFolder.Abs(); err == nil {
-		coreFolder = absCoreFolder
-	} // silently continue if absolute path can't be detected
-	hash := utils.MD5Sum([]byte(coreFolder.String() + optimizationFlags))
-	realName := "core_" + fqbnToUnderscore + "_" + hash + ".a"
+	realName := "core_" + fqbnToUnderscore + "_" + archiveKey + ".a"
 	if len(realName) > 100 {
 		// avoid really long names, simply hash the final part
-		realName = "core_" + utils.MD5Sum([]byte(fqbnToUnderscore+"_"+hash)) + ".a"
+		realName = "core_" + utils.MD5Sum([]byte(fqbnToUnderscore+"_"+archiveKey)) + ".a"
 	}
 	return realName
 }
+
+// CoreArchiveMetadata is the sidecar JSON written next to a cached
+// core_*.a archive (see CoreArchiveMetadataPath), recording enough about
+// how it was built for `arduino-cli cache info` to report on without
+// having to rebuild it.
+type CoreArchiveMetadata struct {
+	FQBN              string    `json:"fqbn"`
+	Platform          string    `json:"platform"`
+	PlatformVersion   string    `json:"platform_version"`
+	CorePath          string    `json:"core_path"`
+	OptimizationFlags string    `json:"optimization_flags"`
+	BuiltAt           time.Time `json:"built_at"`
+}
+
+// CoreArchiveMetadataPath returns the sidecar metadata path for a cached
+// core archive.
+func CoreArchiveMetadataPath(archivePath *paths.Path) *paths.Path {
+	return archivePath.Parent().Join(archivePath.Base() + ".json")
+}
+
+// ReadCoreArchiveMetadata loads the sidecar metadata for archivePath,
+// returning nil if none was written (e.g. an archive cached before this
+// metadata existed).
+func ReadCoreArchiveMetadata(archivePath *paths.Path) (*CoreArchiveMetadata, error) {
+	data, err := CoreArchiveMetadataPath(archivePath).ReadFile()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := &CoreArchiveMetadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// writeCoreArchiveMetadata writes the sidecar metadata for a just-cached
+// core archive built from coreFolder.
+func writeCoreArchiveMetadata(archivePath *paths.Path, buildProperties *properties.Map, coreFolder *paths.Path) error {
+	meta := &CoreArchiveMetadata{
+		FQBN:              buildProperties.Get(constants.BUILD_PROPERTIES_FQBN),
+		Platform:          buildProperties.Get("name"),
+		PlatformVersion:   buildProperties.Get("version"),
+		CorePath:          coreFolder.String(),
+		OptimizationFlags: buildProperties.Get("compiler.optimization_flags"),
+		BuiltAt:           time.Now(),
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return CoreArchiveMetadataPath(archivePath).WriteFile(data)
+}
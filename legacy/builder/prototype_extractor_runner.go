@@ -0,0 +1,45 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"github.com/arduino/arduino-cli/legacy/builder/constants"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	"github.com/pkg/errors"
+)
+
+// PrototypeExtractorRunner populates ctx.Prototypes and
+// ctx.PrototypesLineWhereToInsert using ctx.PrototypeExtractor. It is used
+// in place of CTagsRunner when the context has a PrototypeExtractor set.
+type PrototypeExtractorRunner struct{}
+
+func (s *PrototypeExtractorRunner) Run(ctx *types.Context) error {
+	protos, line, err := ctx.PrototypeExtractor.Extract(ctx, ctx.SourceGccMinusE, ctx.Sketch.MainFile.Name.Base())
+	if err != nil {
+		// A real C++ frontend can fail to parse sketches ctags' looser
+		// heuristics tolerate (unusual preprocessor output, a grammar gap,
+		// a missing/incompatible binary, ...); when it does, fall back to
+		// the legacy ctags pipeline rather than failing the whole build.
+		ctx.GetLogger().Println(constants.LOG_LEVEL_WARN, "Prototype extraction failed, falling back to ctags: {0}", err)
+		return (&CTagsRunner{}).Run(ctx)
+	}
+	if line != -1 {
+		ctx.PrototypesLineWhereToInsert = line
+	}
+	ctx.Prototypes = protos
+
+	return nil
+}
@@ -0,0 +1,176 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package prototypes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+)
+
+// ClangExtractor extracts prototypes by asking clang itself for the AST
+// (`clang -Xclang -ast-dump=json -fsyntax-only`) and walking the resulting
+// tree for top-level FunctionDecl nodes whose location falls inside the
+// sketch's own source (the #line/linemarker directives GCCPreprocRunner's
+// output already carries tell clang which parts of the merged translation
+// unit came from the sketch versus an #included header). Unlike
+// CTagsParser's regex-driven tag scanner, and like TreeSitterExtractor,
+// the declaration text here is sliced verbatim out of the source, so
+// qualifiers, __attribute__((...)),
+// default arguments and template parameter lists all survive exactly as
+// written.
+type ClangExtractor struct {
+	// ClangPath is the clang binary to invoke; left empty, "clang" is
+	// looked up on PATH.
+	ClangPath string
+}
+
+// NewClangExtractor creates an Extractor backed by clang's own AST, via
+// clangPath ("clang" on PATH if empty).
+func NewClangExtractor(clangPath string) *ClangExtractor {
+	return &ClangExtractor{ClangPath: clangPath}
+}
+
+// Name implements Extractor.
+func (e *ClangExtractor) Name() string {
+	return "clang"
+}
+
+type clangLoc struct {
+	File   string `json:"file"`
+	Offset int    `json:"offset"`
+}
+
+type clangRange struct {
+	Begin clangLoc `json:"begin"`
+	End   clangLoc `json:"end"`
+}
+
+type clangNode struct {
+	Kind       string      `json:"kind"`
+	Name       string      `json:"name"`
+	Loc        clangLoc    `json:"loc"`
+	Range      clangRange  `json:"range"`
+	IsImplicit bool        `json:"isImplicit"`
+	Inner      []clangNode `json:"inner"`
+}
+
+// Extract implements Extractor. The declaration text is sliced verbatim out
+// of the source between clang's own reported node boundaries, so a trailing
+// return type ("auto foo() -> int") or angle-bracket-laden default argument
+// (std::map<int,int> m = {}) survives untouched; there's no hand-rolled
+// scanning here to confuse.
+func (e *ClangExtractor) Extract(ctx *types.Context, source string, mainFileName string) ([]*types.Prototype, int, error) {
+	tmpFile, err := os.CreateTemp("", "arduino-clang-prototypes-*.cpp")
+	if err != nil {
+		return nil, -1, err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(source); err != nil {
+		tmpFile.Close()
+		return nil, -1, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, -1, err
+	}
+
+	clangPath := e.ClangPath
+	if clangPath == "" {
+		clangPath = "clang"
+	}
+	cmd := exec.Command(clangPath, "-Xclang", "-ast-dump=json", "-fsyntax-only", "-x", "c++", tmpFile.Name())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, -1, fmt.Errorf("running clang: %w (%s)", err, stderr.String())
+	}
+
+	var root clangNode
+	if err := json.Unmarshal(stdout.Bytes(), &root); err != nil {
+		return nil, -1, fmt.Errorf("parsing clang AST: %w", err)
+	}
+
+	src := []byte(source)
+	var protos []*types.Prototype
+
+	// clang's JSON "loc"/"range" omit the "file" field when it's the same
+	// as the previous node's, so the current file has to be tracked as we
+	// walk rather than read off each node individually.
+	currentFile := ""
+	for _, node := range root.Inner {
+		if node.Loc.File != "" {
+			currentFile = node.Loc.File
+		}
+		if filepath.Base(currentFile) != mainFileName {
+			continue
+		}
+		if node.Kind != "FunctionDecl" || node.IsImplicit || node.Name == "" {
+			continue
+		}
+
+		bodyStart := node.Range.End.Offset
+		for _, inner := range node.Inner {
+			if inner.Kind == "CompoundStmt" {
+				bodyStart = inner.Range.Begin.Offset
+				break
+			}
+		}
+		if bodyStart <= node.Range.Begin.Offset || bodyStart > len(src) {
+			continue
+		}
+
+		proto := strings.TrimSpace(string(src[node.Range.Begin.Offset:bodyStart])) + ";"
+		protos = append(protos, &types.Prototype{
+			FunctionName: node.Name,
+			Prototype:    proto,
+			Line:         lineAt(src, node.Range.Begin.Offset),
+		})
+	}
+
+	return protos, lastIncludeLine(source), nil
+}
+
+// lineAt returns the zero-based line number offset falls on within src.
+func lineAt(src []byte, offset int) int {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	return bytes.Count(src[:offset], []byte("\n"))
+}
+
+// lastIncludeLine returns the line right after the last top-level
+// #include in source, matching CTagsParser's insertion point convention,
+// or -1 if none was found.
+func lastIncludeLine(source string) int {
+	insertLine := -1
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	line := 0
+	for scanner.Scan() {
+		if strings.HasPrefix(strings.TrimSpace(scanner.Text()), "#include") {
+			insertLine = line + 1
+		}
+		line++
+	}
+	return insertLine
+}
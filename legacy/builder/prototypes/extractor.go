@@ -0,0 +1,29 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package prototypes extracts function prototypes that need to be
+// forward-declared into the sketch, much like the Arduino IDE's
+// ctags-based preprocessing step, but through pluggable Extractor
+// implementations. The original ctags.CTagsParser remains the default;
+// this package lets it be swapped for a real C++ frontend (tree-sitter,
+// libclang, ...) that understands more of the language than ctags' tag
+// heuristics do.
+package prototypes
+
+import "github.com/arduino/arduino-cli/legacy/builder/types"
+
+// Extractor is an alias for types.PrototypeExtractor, kept in this
+// package so implementations read naturally as prototypes.Extractor.
+type Extractor = types.PrototypeExtractor
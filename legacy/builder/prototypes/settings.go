@@ -0,0 +1,52 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package prototypes
+
+import (
+	"fmt"
+
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+)
+
+// NewFromSettings builds the Extractor selected by the
+// "builder.prototypes_extractor" setting: "" or "ctags" (the default)
+// returns a nil Extractor, leaving the legacy CTagsRunner/PrototypesAdder
+// pair in charge; "treesitter" and "clang" return the Extractor of the
+// same name in this package, the latter invoking the binary named by
+// "builder.clang_path" ("clang" on PATH if unset).
+func NewFromSettings() (types.PrototypeExtractor, error) {
+	return NewFromParser(configuration.Settings.GetString("builder.prototypes_extractor"))
+}
+
+// NewFromParser builds the Extractor named by parser directly, without
+// consulting configuration. NewFromSettings is just this applied to the
+// "builder.prototypes_extractor" setting; it's also called directly by
+// ContainerAddPrototypes when ctx.PrototypesParser is set, letting a
+// caller force "ctags" or "clang" for a single build regardless of that
+// setting.
+func NewFromParser(parser string) (types.PrototypeExtractor, error) {
+	switch parser {
+	case "", "ctags":
+		return nil, nil
+	case "treesitter":
+		return NewTreeSitterExtractor(), nil
+	case "clang":
+		return NewClangExtractor(configuration.Settings.GetString("builder.clang_path")), nil
+	default:
+		return nil, fmt.Errorf("unknown prototypes parser %q", parser)
+	}
+}
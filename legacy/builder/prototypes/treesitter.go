@@ -0,0 +1,167 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package prototypes
+
+import (
+	"context"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/cpp"
+
+	"github.com/arduino/arduino-cli/legacy/builder/constants"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+)
+
+// TreeSitterExtractor extracts prototypes by parsing the preprocessed
+// sketch with tree-sitter's C++ grammar, walking the resulting AST for
+// top-level function_definition nodes. Unlike ctags' regex-driven tag
+// scanner, this understands C++ grammar well enough to avoid misfiring
+// on class members, templates or nested braces; a trailing return type or
+// angle-bracket-laden default argument is just more text inside the
+// declarator's byte range, so it comes along for free when the prototype
+// text is sliced straight out of source.
+type TreeSitterExtractor struct {
+	parser *sitter.Parser
+}
+
+// NewTreeSitterExtractor creates an Extractor backed by tree-sitter-cpp.
+func NewTreeSitterExtractor() *TreeSitterExtractor {
+	parser := sitter.NewParser()
+	parser.SetLanguage(cpp.GetLanguage())
+	return &TreeSitterExtractor{parser: parser}
+}
+
+// Name implements Extractor.
+func (e *TreeSitterExtractor) Name() string {
+	return "treesitter"
+}
+
+// Extract implements Extractor.
+func (e *TreeSitterExtractor) Extract(ctx *types.Context, source string, mainFileName string) ([]*types.Prototype, int, error) {
+	tree, err := e.parser.ParseCtx(context.Background(), nil, []byte(source))
+	if err != nil {
+		return nil, -1, err
+	}
+	defer tree.Close()
+
+	root := tree.RootNode()
+	src := []byte(source)
+
+	var protos []*types.Prototype
+	insertLine := -1
+
+	for i := 0; i < int(root.ChildCount()); i++ {
+		child := root.Child(i)
+		if child.Type() != "function_definition" {
+			if child.Type() == "preproc_include" {
+				// Prototypes are inserted right after the last top-level
+				// #include, matching the ctags behavior.
+				insertLine = int(child.EndPoint().Row) + 1
+			}
+			continue
+		}
+
+		declarator := child.ChildByFieldName("declarator")
+		if declarator == nil {
+			continue
+		}
+		name := functionName(declarator, src)
+		if name == "" || name == mainFileNameWithoutExt(mainFileName) {
+			continue
+		}
+
+		// "auto foo() -> int" and "std::function<void()> bar()" both keep
+		// a fixed return type a prototype can repeat; "auto foo() { ... }"
+		// (and "decltype(auto) foo() { ... }") don't, since the compiler
+		// only learns the real return type from the body itself, so a
+		// separate forward declaration of one can't be written at all.
+		if isDeducedAutoReturn(child, declarator, src) {
+			ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, "Can't forward-declare {0}: it has a deduced return type", name)
+			continue
+		}
+
+		proto := strings.TrimSpace(string(src[child.StartByte():bodyStart(child)])) + ";"
+		protos = append(protos, &types.Prototype{
+			FunctionName: name,
+			Prototype:    proto,
+			Line:         int(child.StartPoint().Row),
+		})
+	}
+
+	return protos, insertLine, nil
+}
+
+// bodyStart returns the byte offset of the function's body (the `{`),
+// falling back to the end of the node if no body child can be found.
+func bodyStart(fn *sitter.Node) uint32 {
+	if body := fn.ChildByFieldName("body"); body != nil {
+		return body.StartByte()
+	}
+	return fn.EndByte()
+}
+
+// functionName walks down a (possibly nested, e.g. pointer/reference)
+// declarator to find the innermost identifier, which tree-sitter-cpp
+// exposes as a field of the declarator chain rather than a single node.
+func functionName(declarator *sitter.Node, src []byte) string {
+	node := declarator
+	for node != nil {
+		if node.Type() == "identifier" || node.Type() == "field_identifier" {
+			return node.Content(src)
+		}
+		if d := node.ChildByFieldName("declarator"); d != nil {
+			node = d
+			continue
+		}
+		break
+	}
+	return ""
+}
+
+// isDeducedAutoReturn reports whether fn's return type is "auto" or
+// "decltype(auto)" with no trailing return type to pin it down, e.g.
+// "auto foo() { return 1; }" rather than "auto foo() -> int { ... }".
+func isDeducedAutoReturn(fn *sitter.Node, declarator *sitter.Node, src []byte) bool {
+	returnType := fn.ChildByFieldName("type")
+	if returnType == nil {
+		return false
+	}
+	switch strings.TrimSpace(returnType.Content(src)) {
+	case "auto", "decltype(auto)":
+	default:
+		return false
+	}
+	return trailingReturnType(declarator) == nil
+}
+
+// trailingReturnType finds the "-> TYPE" clause tree-sitter-cpp attaches to
+// a function_declarator, if any.
+func trailingReturnType(declarator *sitter.Node) *sitter.Node {
+	for i := 0; i < int(declarator.ChildCount()); i++ {
+		if c := declarator.Child(i); c.Type() == "trailing_return_type" {
+			return c
+		}
+	}
+	return nil
+}
+
+func mainFileNameWithoutExt(name string) string {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,165 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	"github.com/pkg/errors"
+)
+
+// prototypesCacheFileName is where PrototypesCache is stored, under
+// ctx.PreprocPath (ctx.BuildPath's "preproc" subdirectory).
+const prototypesCacheFileName = "prototypes.cache.json"
+
+// PrototypesCacheEntry is the previously computed ContainerAddPrototypes
+// result for one merged-source hash, plus the build inputs it depended on.
+// Those are rechecked on load so a hash collision, or simply switching FQBN
+// or a library version without touching the sketch, can't resurrect
+// prototypes computed for a different build.
+type PrototypesCacheEntry struct {
+	PrototypesSection           string                                    `json:"prototypesSection"`
+	PrototypesLineWhereToInsert int                                       `json:"prototypesLineWhereToInsert"`
+	IncludeFolders              []string                                  `json:"includeFolders"`
+	FQBN                        string                                    `json:"fqbn"`
+	ArduinoAPIVersion           string                                    `json:"arduinoAPIVersion"`
+	Libraries                   map[string]string                        `json:"libraries"` // library name -> "version@installDir"
+	LibrariesResolutionResults  map[string]types.LibraryResolutionResult `json:"librariesResolutionResults"`
+	// Backend identifies which PrototypeExtractor produced
+	// PrototypesSection ("ctags", "treesitter", "clang"; see
+	// prototypeExtractorBackendName), so switching
+	// builder.prototypes_extractor/ctx.PrototypesParser on an otherwise
+	// unchanged sketch invalidates the entry instead of serving a
+	// PrototypesSection generated by a different backend.
+	Backend string `json:"backend"`
+}
+
+// prototypeExtractorBackendName identifies the extractor ctx will use:
+// "ctags" when ctx.PrototypeExtractor is nil (the legacy CTagsRunner
+// path), otherwise whatever the configured Extractor reports via Name().
+func prototypeExtractorBackendName(ctx *types.Context) string {
+	if ctx.PrototypeExtractor == nil {
+		return "ctags"
+	}
+	return ctx.PrototypeExtractor.Name()
+}
+
+// PrototypesCache is the on-disk shape of preproc/prototypes.cache.json:
+// sha256(merged source) -> the cached result for that exact source.
+type PrototypesCache map[string]PrototypesCacheEntry
+
+// prototypesCacheKey hashes the merged sketch source as it stood before
+// prototype insertion (ctx.SourceGccMinusE): any change to the sketch's own
+// code, or to any #include it resolves to, changes this source and
+// therefore the key.
+func prototypesCacheKey(preprocessedSource string) string {
+	sum := sha256.Sum256([]byte(preprocessedSource))
+	return hex.EncodeToString(sum[:])
+}
+
+// currentLibraries summarizes ctx.ImportedLibraries as
+// name -> "version@installDir", both for the metadata an entry is saved
+// with and for checking one loaded back against the current build.
+func currentLibraries(ctx *types.Context) map[string]string {
+	libs := map[string]string{}
+	for _, lib := range ctx.ImportedLibraries {
+		version := ""
+		if lib.Version != nil {
+			version = lib.Version.String()
+		}
+		libs[lib.Name] = fmt.Sprintf("%s@%s", version, lib.InstallDir)
+	}
+	return libs
+}
+
+// sameLibraries reports whether cached matches ctx's currently resolved
+// libraries: the same set, each still at the same version and path.
+func sameLibraries(cached map[string]string, ctx *types.Context) bool {
+	current := currentLibraries(ctx)
+	if len(cached) != len(current) {
+		return false
+	}
+	for name, meta := range current {
+		if cached[name] != meta {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPrototypesCache looks up key in preproc/prototypes.cache.json and
+// returns the entry only if it's still valid for ctx's FQBN,
+// ArduinoAPIVersion and resolved libraries. Any read, parse or validation
+// failure is treated as a cache miss rather than an error, since a stale or
+// corrupt cache must never fail the build; ctx.DisablePrototypeCache always
+// misses.
+func loadPrototypesCache(ctx *types.Context, key string) *PrototypesCacheEntry {
+	if ctx.DisablePrototypeCache {
+		return nil
+	}
+	data, err := ctx.PreprocPath.Join(prototypesCacheFileName).ReadFile()
+	if err != nil {
+		return nil
+	}
+	cache := PrototypesCache{}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil
+	}
+	entry, ok := cache[key]
+	if !ok {
+		return nil
+	}
+	if entry.FQBN != ctx.FQBN.String() || entry.ArduinoAPIVersion != ctx.ArduinoAPIVersion {
+		return nil
+	}
+	if entry.Backend != prototypeExtractorBackendName(ctx) {
+		return nil
+	}
+	if !sameLibraries(entry.Libraries, ctx) {
+		return nil
+	}
+	return &entry
+}
+
+// savePrototypesCache records entry under key in
+// preproc/prototypes.cache.json, preserving whatever else is already
+// cached there (e.g. a different FQBN's hash) instead of overwriting it.
+// ctx.DisablePrototypeCache makes this a no-op.
+func savePrototypesCache(ctx *types.Context, key string, entry PrototypesCacheEntry) error {
+	if ctx.DisablePrototypeCache {
+		return nil
+	}
+	cacheFile := ctx.PreprocPath.Join(prototypesCacheFileName)
+
+	cache := PrototypesCache{}
+	if data, err := cacheFile.ReadFile(); err == nil {
+		_ = json.Unmarshal(data, &cache) // a corrupt cache just starts fresh
+	}
+	cache[key] = entry
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ctx.PreprocPath.MkdirAll(); err != nil {
+		return errors.WithStack(err)
+	}
+	return cacheFile.WriteFile(data)
+}
@@ -16,7 +16,10 @@
 package builder
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
+	"os/exec"
 	"sort"
 	"strings"
 
@@ -24,10 +27,36 @@ import (
 	"github.com/arduino/arduino-cli/legacy/builder/constants"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	"github.com/arduino/arduino-cli/legacy/builder/utils"
+	paths "github.com/arduino/go-paths-helper"
 	properties "github.com/arduino/go-properties-orderedmap"
 	"github.com/pkg/errors"
 )
 
+// hookJSONProtocol is the "recipe.hooks.<phase>.NN.protocol" value that
+// opts a hook into structured JSON stdin/stdout instead of plain-exec
+// semantics.
+const hookJSONProtocol = "json"
+
+// hookRequest is the JSON document a recipe.hooks.*.protocol=json hook
+// receives on stdin: enough of the current build state for a hook to make
+// a decision (e.g. "am I building for an ESP32?") without shelling back
+// out to arduino-cli itself.
+type hookRequest struct {
+	Properties  map[string]string `json:"properties"`
+	Sketch      string            `json:"sketch,omitempty"`
+	FQBN        string            `json:"fqbn,omitempty"`
+	ObjectFiles []string          `json:"object_files,omitempty"`
+}
+
+// hookResponse is the JSON document a recipe.hooks.*.protocol=json hook
+// may print to stdout: Properties are merged into ctx.BuildProperties (and
+// the properties clone this runner's own remaining recipes use), so a hook
+// can append -D defines, override build.flags.ldflags, or add extra source
+// directories discovered at build time.
+type hookResponse struct {
+	Properties map[string]string `json:"properties"`
+}
+
 type RecipeByPrefixSuffixRunner struct {
 	Prefix string
 	Suffix string
@@ -66,6 +95,19 @@ func (s *RecipeByPrefixSuffixRunner) Run(ctx *types.Context) error {
 			return nil
 		}
 
+		protocolKey := strings.TrimSuffix(recipe, s.Suffix) + ".protocol"
+		if properties.Get(protocolKey) == hookJSONProtocol {
+			response, err := s.runJSONHook(ctx, command, properties)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for key, value := range response.Properties {
+				properties.Set(key, value)
+				ctx.BuildProperties.Set(key, value)
+			}
+			continue
+		}
+
 		_, _, err = utils.ExecCommand(ctx, command, utils.ShowIfVerbose /* stdout */, utils.Show /* stderr */)
 		if err != nil {
 			return errors.WithStack(err)
@@ -76,6 +118,60 @@ func (s *RecipeByPrefixSuffixRunner) Run(ctx *types.Context) error {
 
 }
 
+// runJSONHook feeds command a hookRequest describing the current build on
+// stdin and parses the hookResponse it prints to stdout, for recipes
+// opted into "protocol=json" (see hookJSONProtocol). It's the structured
+// analogue of a container build tool reading a Dockerfile off
+// /dev/stdin: the hook gets machine-readable context instead of having to
+// re-derive it from argv/env, and can hand build-property overrides back
+// the same way.
+func (s *RecipeByPrefixSuffixRunner) runJSONHook(ctx *types.Context, command *exec.Cmd, buildProperties *properties.Map) (hookResponse, error) {
+	props := map[string]string{}
+	for _, key := range buildProperties.Keys() {
+		props[key] = buildProperties.Get(key)
+	}
+	request := hookRequest{Properties: props}
+	if ctx.SketchLocation != nil {
+		request.Sketch = ctx.SketchLocation.String()
+	}
+	if ctx.FQBN != nil {
+		request.FQBN = ctx.FQBN.String()
+	}
+	for _, objectFile := range objectFilesForHook(ctx) {
+		request.ObjectFiles = append(request.ObjectFiles, objectFile.String())
+	}
+
+	stdin, err := json.Marshal(request)
+	if err != nil {
+		return hookResponse{}, errors.WithStack(err)
+	}
+	command.Stdin = bytes.NewReader(stdin)
+
+	stdout, _, err := utils.ExecCommand(ctx, command, utils.Capture /* stdout */, utils.Show /* stderr */)
+	if err != nil {
+		return hookResponse{}, errors.WithStack(err)
+	}
+
+	var response hookResponse
+	if len(bytes.TrimSpace(stdout)) > 0 {
+		if err := json.Unmarshal(stdout, &response); err != nil {
+			return hookResponse{}, errors.Wrapf(err, "parsing JSON response from hook %s", command.Path)
+		}
+	}
+	return response, nil
+}
+
+// objectFilesForHook returns every object file compiled so far (core,
+// libraries, sketch), in whatever order the build produced them, for a
+// JSON hook's "object_files" field.
+func objectFilesForHook(ctx *types.Context) paths.PathList {
+	var all paths.PathList
+	all = append(all, ctx.CoreObjectsFiles...)
+	all = append(all, ctx.LibrariesObjectFiles...)
+	all = append(all, ctx.SketchObjectFiles...)
+	return all
+}
+
 func findRecipes(buildProperties *properties.Map, patternPrefix string, patternSuffix string) []string {
 	var recipes []string
 	for _, key := range buildProperties.Keys() {
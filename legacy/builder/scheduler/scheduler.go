@@ -0,0 +1,149 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package scheduler builds a dependency graph of build actions (preprocess,
+// ctags, compile, archive, link, size...) and runs the ready ones on a
+// worker pool sized by Context.Jobs, instead of the ad-hoc goroutine usage
+// scattered across the legacy builder. It gives predictable N-way
+// parallelism on multi-core hosts while still respecting ordering
+// constraints between phases.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Node is a single build action in the graph. DependsOn lists the IDs of
+// nodes that must complete successfully before Action runs.
+type Node struct {
+	ID         string
+	DependsOn  []string
+	Action     func(ctx context.Context) error
+}
+
+// Graph is a set of Nodes to be executed respecting their dependencies.
+type Graph struct {
+	nodes map[string]*Node
+	order []string
+}
+
+// NewGraph creates an empty Graph.
+func NewGraph() *Graph {
+	return &Graph{nodes: map[string]*Node{}}
+}
+
+// Add registers a node in the graph. Panics on a duplicate ID, since that
+// always indicates a bug in how the graph is being built.
+func (g *Graph) Add(n *Node) {
+	if _, exists := g.nodes[n.ID]; exists {
+		panic(fmt.Sprintf("scheduler: duplicate node %q", n.ID))
+	}
+	g.nodes[n.ID] = n
+	g.order = append(g.order, n.ID)
+}
+
+// validate reports an error naming the first node whose DependsOn lists an
+// ID that was never Add()ed.
+func (g *Graph) validate() error {
+	for _, id := range g.order {
+		for _, dep := range g.nodes[id].DependsOn {
+			if _, exists := g.nodes[dep]; !exists {
+				return fmt.Errorf("scheduler: node %q depends on unknown node %q", id, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes all nodes in the graph on a worker pool sized by jobs (if
+// jobs is 0, runtime.NumCPU() is used), honoring dependency ordering. The
+// first action to fail cancels the passed-in context so in-flight and
+// not-yet-started actions can stop early; Run returns that first error.
+//
+// Run validates every DependsOn reference before starting any node: a
+// dependency on an ID that was never Add()ed (a typo, or a node omitted by
+// some conditional) would otherwise leave that node waiting on a nil
+// channel forever, hanging Run with no diagnostic.
+func (g *Graph) Run(ctx context.Context, jobs int) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(map[string]chan struct{}, len(g.nodes))
+	for id := range g.nodes {
+		done[id] = make(chan struct{})
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, jobs)
+	)
+
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for _, id := range g.order {
+		node := g.nodes[id]
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			defer close(done[node.ID])
+
+			// Wait for dependencies, bailing out early if the overall
+			// run has already been cancelled.
+			for _, dep := range node.DependsOn {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := node.Action(ctx); err != nil {
+				setErr(err)
+			}
+		}(node)
+	}
+
+	wg.Wait()
+	return firstErr
+}
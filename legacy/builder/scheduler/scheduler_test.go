@@ -0,0 +1,134 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphRunRespectsDependencyOrder(t *testing.T) {
+	g := NewGraph()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(id string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	g.Add(&Node{ID: "preprocess", Action: record("preprocess")})
+	g.Add(&Node{ID: "ctags", DependsOn: []string{"preprocess"}, Action: record("ctags")})
+	g.Add(&Node{ID: "compile", DependsOn: []string{"ctags"}, Action: record("compile")})
+	g.Add(&Node{ID: "link", DependsOn: []string{"compile"}, Action: record("link")})
+
+	require.NoError(t, g.Run(context.Background(), 2))
+	require.Equal(t, []string{"preprocess", "ctags", "compile", "link"}, order)
+}
+
+func TestGraphRunRunsIndependentNodesConcurrently(t *testing.T) {
+	g := NewGraph()
+
+	const n = 4
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		g.Add(&Node{
+			ID: string(rune('a' + i)),
+			Action: func(context.Context) error {
+				wg.Done()
+				<-release
+				return nil
+			},
+		})
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.Run(context.Background(), n) }()
+
+	// If the n independent nodes weren't actually running concurrently,
+	// this would never unblock: each one parks on release until every
+	// other one has also reached it.
+	waited := make(chan struct{})
+	go func() { wg.Wait(); close(waited) }()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("independent nodes did not all start concurrently")
+	}
+	close(release)
+
+	require.NoError(t, <-done)
+}
+
+func TestGraphRunCancelsOnFirstError(t *testing.T) {
+	g := NewGraph()
+
+	boom := errors.New("boom")
+	var started int32
+	var mu sync.Mutex
+	g.Add(&Node{ID: "fails", Action: func(context.Context) error { return boom }})
+	g.Add(&Node{
+		ID:        "neverRuns",
+		DependsOn: []string{"fails"},
+		Action: func(context.Context) error {
+			mu.Lock()
+			started++
+			mu.Unlock()
+			return nil
+		},
+	})
+
+	err := g.Run(context.Background(), 1)
+	require.Equal(t, boom, err)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, int32(0), started, "a node depending on a failed node must not run")
+}
+
+func TestGraphRunRejectsUnknownDependency(t *testing.T) {
+	g := NewGraph()
+	g.Add(&Node{ID: "compile", DependsOn: []string{"typo-ctags"}, Action: func(context.Context) error { return nil }})
+
+	done := make(chan error, 1)
+	go func() { done <- g.Run(context.Background(), 1) }()
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "typo-ctags")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run hung instead of rejecting the unknown dependency")
+	}
+}
+
+func TestGraphAddPanicsOnDuplicateID(t *testing.T) {
+	g := NewGraph()
+	g.Add(&Node{ID: "compile", Action: func(context.Context) error { return nil }})
+	require.Panics(t, func() {
+		g.Add(&Node{ID: "compile", Action: func(context.Context) error { return nil }})
+	})
+}
@@ -0,0 +1,137 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	"github.com/arduino/arduino-cli/legacy/builder/utils"
+	paths "github.com/arduino/go-paths-helper"
+	"github.com/pkg/errors"
+)
+
+// sourceMapFileName is the name SavePreprocessedSourceMap saves ctx.SourceMap
+// under, inside ctx.PreprocPath.
+const sourceMapFileName = "sketch_merged.cpp.map.json"
+
+// buildSourceMap derives a types.SourceMapEntry per "#line N \"path\""
+// marker in source, tagging the run it opens as "prototype" when it's one
+// of the declarations ContainerAddPrototypes spliced in from
+// prototypesSection, or "sketch" otherwise. Each entry's MergedLineEnd
+// extends up to (but not including) the next marker, or to the end of
+// source for the last one.
+func buildSourceMap(source string, prototypesSection string) []types.SourceMapEntry {
+	var entries []types.SourceMapEntry
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#line ") {
+			continue
+		}
+		fields := strings.SplitN(trimmed[len("#line "):], " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		originalLine, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		file, _, ok := utils.ParseCppString(fields[1])
+		if !ok {
+			continue
+		}
+
+		reason := "sketch"
+		if prototypesSection != "" && strings.Contains(prototypesSection, line+"\n") {
+			reason = "prototype"
+		}
+
+		if n := len(entries); n > 0 {
+			entries[n-1].MergedLineEnd = i // line right before this marker
+		}
+		entries = append(entries, types.SourceMapEntry{
+			// The marker itself replaces a physical line of the merged
+			// source; the mapping it opens starts on the line right after.
+			MergedLineStart: i + 2,
+			OriginalFile:    paths.New(file),
+			OriginalLine:    originalLine,
+			Reason:          reason,
+		})
+	}
+	if n := len(entries); n > 0 {
+		entries[n-1].MergedLineEnd = len(lines)
+	}
+	return entries
+}
+
+// SavePreprocessedSourceMap persists ctx.SourceMap as sourceMapFileName
+// under ctx.PreprocPath, so that callers embedding the builder as a library
+// can decode merged-file diagnostic locations without re-parsing
+// ctx.Source themselves.
+type SavePreprocessedSourceMap struct{}
+
+func (s *SavePreprocessedSourceMap) Run(ctx *types.Context) error {
+	data, err := json.MarshalIndent(ctx.SourceMap, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ctx.PreprocPath.MkdirAll(); err != nil {
+		return errors.WithStack(err)
+	}
+	return ctx.PreprocPath.Join(sourceMapFileName).WriteFile(data)
+}
+
+// diagnosticLineRegexp matches the "file:line:col: " prefix gcc and clang
+// use to open a diagnostic line.
+var diagnosticLineRegexp = regexp.MustCompile(`^([^:\n]+):(\d+):(\d+:)?(.*)$`)
+
+// RemapDiagnostics copies Reader to Writer line by line, rewriting any
+// diagnostic that points into the merged sketch .cpp file to the original
+// .ino/.cpp location recorded in ctx.SourceMap. The compiler itself already
+// honors the "#line" markers in ctx.Source, so this exists for tools
+// (linters, static analyzers) that read the merged file directly and report
+// against its raw line numbers instead.
+type RemapDiagnostics struct {
+	Reader io.Reader
+	Writer io.Writer
+}
+
+func (s *RemapDiagnostics) Run(ctx *types.Context) error {
+	mergedFile := ctx.SketchBuildPath.Join(ctx.Sketch.MainFile.Name.Base() + ".cpp").String()
+
+	scanner := bufio.NewScanner(s.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := diagnosticLineRegexp.FindStringSubmatch(line); m != nil && m[1] == mergedFile {
+			if lineNo, err := strconv.Atoi(m[2]); err == nil {
+				if file, originalLine := ctx.Lookup(lineNo); file != nil {
+					line = fmt.Sprintf("%s:%d:%s%s", file, originalLine, m[3], m[4])
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(s.Writer, line); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
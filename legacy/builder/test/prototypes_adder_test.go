@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/arduino/arduino-cli/legacy/builder"
+	"github.com/arduino/arduino-cli/legacy/builder/prototypes"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	"github.com/arduino/arduino-cli/legacy/builder/utils"
 	paths "github.com/arduino/go-paths-helper"
@@ -71,6 +72,11 @@ func TestPrototypesAdderBridgeExample(t *testing.T) {
 
 	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
 	require.Equal(t, "#line 33 "+quotedSketchLocation+"\nvoid setup();\n#line 46 "+quotedSketchLocation+"\nvoid loop();\n#line 62 "+quotedSketchLocation+"\nvoid process(BridgeClient client);\n#line 82 "+quotedSketchLocation+"\nvoid digitalCommand(BridgeClient client);\n#line 109 "+quotedSketchLocation+"\nvoid analogCommand(BridgeClient client);\n#line 149 "+quotedSketchLocation+"\nvoid modeCommand(BridgeClient client);\n#line 33 "+quotedSketchLocation+"\n", ctx.PrototypesSection)
+
+	require.NotEmpty(t, ctx.SourceMap)
+	file, line := ctx.Lookup(2)
+	require.Equal(t, Abs(t, sketchLocation).String(), file.String())
+	require.Equal(t, 1, line)
 }
 
 func TestPrototypesAdderSketchWithIfDef(t *testing.T) {
@@ -111,6 +117,24 @@ func TestPrototypesAdderSketchWithIfDef(t *testing.T) {
 
 	preprocessed := LoadAndInterpolate(t, filepath.Join("SketchWithIfDef", "SketchWithIfDef.preprocessed.txt"), ctx)
 	require.Equal(t, preprocessed, strings.Replace(ctx.Source, "\r\n", "\n", -1))
+
+	assertSourceMapRoundTrips(t, ctx)
+}
+
+// assertSourceMapRoundTrips checks that every run recorded in ctx.SourceMap
+// maps both its first and last merged line back to the original file/line
+// the entry claims, via ctx.Lookup.
+func assertSourceMapRoundTrips(t *testing.T, ctx *types.Context) {
+	require.NotEmpty(t, ctx.SourceMap)
+	for _, entry := range ctx.SourceMap {
+		file, line := ctx.Lookup(entry.MergedLineStart)
+		require.Equal(t, entry.OriginalFile.String(), file.String())
+		require.Equal(t, entry.OriginalLine, line)
+
+		file, line = ctx.Lookup(entry.MergedLineEnd)
+		require.Equal(t, entry.OriginalFile.String(), file.String())
+		require.Equal(t, entry.OriginalLine+(entry.MergedLineEnd-entry.MergedLineStart), line)
+	}
 }
 
 func TestPrototypesAdderBaladuino(t *testing.T) {
@@ -316,46 +340,65 @@ func TestPrototypesAdderStringWithComment(t *testing.T) {
 func TestPrototypesAdderSketchWithStruct(t *testing.T) {
 	DownloadCoresAndToolsAndLibraries(t)
 
-	ctx := &types.Context{
-		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
-		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
-		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
-		OtherLibrariesDirs:   paths.NewPathList("libraries"),
-		SketchLocation:       paths.New("SketchWithStruct", "SketchWithStruct.ino"),
-		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
-		ArduinoAPIVersion:    "10600",
-		Verbose:              true,
+	newCtx := func() *types.Context {
+		return &types.Context{
+			HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+			BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+			BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+			OtherLibrariesDirs:   paths.NewPathList("libraries"),
+			SketchLocation:       paths.New("SketchWithStruct", "SketchWithStruct.ino"),
+			FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+			ArduinoAPIVersion:    "10600",
+			Verbose:              true,
+		}
 	}
+	runPrototypesAdder := func(t *testing.T, ctx *types.Context) {
+		buildPath := SetupBuildPath(t, ctx)
+		defer buildPath.RemoveAll()
 
-	buildPath := SetupBuildPath(t, ctx)
-	defer buildPath.RemoveAll()
+		commands := []types.Command{
 
-	commands := []types.Command{
+			&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
 
-		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+			&builder.ContainerMergeCopySketchFiles{},
 
-		&builder.ContainerMergeCopySketchFiles{},
+			&builder.ContainerFindIncludes{},
 
-		&builder.ContainerFindIncludes{},
+			&builder.PrintUsedLibrariesIfVerbose{},
+			&builder.WarnAboutArchIncompatibleLibraries{},
 
-		&builder.PrintUsedLibrariesIfVerbose{},
-		&builder.WarnAboutArchIncompatibleLibraries{},
+			&builder.ContainerAddPrototypes{},
+		}
 
-		&builder.ContainerAddPrototypes{},
+		for _, command := range commands {
+			err := command.Run(ctx)
+			NoError(t, err)
+		}
 	}
 
-	for _, command := range commands {
-		err := command.Run(ctx)
-		NoError(t, err)
-	}
-
-	preprocessed := LoadAndInterpolate(t, filepath.Join("SketchWithStruct", "SketchWithStruct.preprocessed.txt"), ctx)
-	obtained := strings.Replace(ctx.Source, "\r\n", "\n", -1)
-	// ctags based preprocessing removes the space after "dostuff", but this is still OK
-	// TODO: remove this exception when moving to a more powerful parser
-	preprocessed = strings.Replace(preprocessed, "void dostuff (A_NEW_TYPE * bar);", "void dostuff(A_NEW_TYPE * bar);", 1)
-	obtained = strings.Replace(obtained, "void dostuff (A_NEW_TYPE * bar);", "void dostuff(A_NEW_TYPE * bar);", 1)
-	require.Equal(t, preprocessed, obtained)
+	t.Run("ctags", func(t *testing.T) {
+		ctx := newCtx()
+		runPrototypesAdder(t, ctx)
+
+		preprocessed := LoadAndInterpolate(t, filepath.Join("SketchWithStruct", "SketchWithStruct.preprocessed.txt"), ctx)
+		obtained := strings.Replace(ctx.Source, "\r\n", "\n", -1)
+		// ctags based preprocessing removes the space after "dostuff", but this is still OK
+		preprocessed = strings.Replace(preprocessed, "void dostuff (A_NEW_TYPE * bar);", "void dostuff(A_NEW_TYPE * bar);", 1)
+		obtained = strings.Replace(obtained, "void dostuff (A_NEW_TYPE * bar);", "void dostuff(A_NEW_TYPE * bar);", 1)
+		require.Equal(t, preprocessed, obtained)
+	})
+
+	t.Run("clang", func(t *testing.T) {
+		ctx := newCtx()
+		ctx.PrototypeExtractor = prototypes.NewClangExtractor("")
+		runPrototypesAdder(t, ctx)
+
+		// unlike ctags, clang preserves the space after "dostuff" verbatim,
+		// so the fixture needs no adjustment here.
+		preprocessed := LoadAndInterpolate(t, filepath.Join("SketchWithStruct", "SketchWithStruct.preprocessed.txt"), ctx)
+		obtained := strings.Replace(ctx.Source, "\r\n", "\n", -1)
+		require.Equal(t, preprocessed, obtained)
+	})
 }
 
 func TestPrototypesAdderSketchWithConfig(t *testing.T) {
@@ -539,52 +582,70 @@ func TestPrototypesAdderSketchWithInlineFunction(t *testing.T) {
 	sketchLocation := paths.New("sketch_with_inline_function", "sketch_with_inline_function.ino")
 	quotedSketchLocation := utils.QuoteCppPath(Abs(t, sketchLocation))
 
-	ctx := &types.Context{
-		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
-		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
-		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
-		OtherLibrariesDirs:   paths.NewPathList("libraries"),
-		SketchLocation:       sketchLocation,
-		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
-		ArduinoAPIVersion:    "10600",
-		Verbose:              true,
+	newCtx := func() *types.Context {
+		return &types.Context{
+			HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+			BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+			BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+			OtherLibrariesDirs:   paths.NewPathList("libraries"),
+			SketchLocation:       sketchLocation,
+			FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+			ArduinoAPIVersion:    "10600",
+			Verbose:              true,
+		}
 	}
+	runPrototypesAdder := func(t *testing.T, ctx *types.Context) {
+		buildPath := SetupBuildPath(t, ctx)
+		defer buildPath.RemoveAll()
 
-	buildPath := SetupBuildPath(t, ctx)
-	defer buildPath.RemoveAll()
+		commands := []types.Command{
 
-	commands := []types.Command{
+			&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
 
-		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+			&builder.ContainerMergeCopySketchFiles{},
 
-		&builder.ContainerMergeCopySketchFiles{},
+			&builder.ContainerFindIncludes{},
 
-		&builder.ContainerFindIncludes{},
+			&builder.PrintUsedLibrariesIfVerbose{},
+			&builder.WarnAboutArchIncompatibleLibraries{},
 
-		&builder.PrintUsedLibrariesIfVerbose{},
-		&builder.WarnAboutArchIncompatibleLibraries{},
+			&builder.ContainerAddPrototypes{},
+		}
 
-		&builder.ContainerAddPrototypes{},
-	}
+		for _, command := range commands {
+			err := command.Run(ctx)
+			NoError(t, err)
+		}
 
-	for _, command := range commands {
-		err := command.Run(ctx)
-		NoError(t, err)
+		require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
 	}
 
-	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
-
-	expected := "#line 1 " + quotedSketchLocation + "\nvoid setup();\n#line 2 " + quotedSketchLocation + "\nvoid loop();\n#line 4 " + quotedSketchLocation + "\nshort unsigned int testInt();\n#line 8 " + quotedSketchLocation + "\nstatic int8_t testInline();\n#line 12 " + quotedSketchLocation + "\n__attribute__((always_inline)) uint8_t testAttribute();\n#line 1 " + quotedSketchLocation + "\n"
-	obtained := ctx.PrototypesSection
-	// ctags based preprocessing removes "inline" but this is still OK
-	// TODO: remove this exception when moving to a more powerful parser
-	expected = strings.Replace(expected, "static inline int8_t testInline();", "static int8_t testInline();", -1)
-	obtained = strings.Replace(obtained, "static inline int8_t testInline();", "static int8_t testInline();", -1)
-	// ctags based preprocessing removes "__attribute__ ....." but this is still OK
-	// TODO: remove this exception when moving to a more powerful parser
-	expected = strings.Replace(expected, "__attribute__((always_inline)) uint8_t testAttribute();", "uint8_t testAttribute();", -1)
-	obtained = strings.Replace(obtained, "__attribute__((always_inline)) uint8_t testAttribute();", "uint8_t testAttribute();", -1)
-	require.Equal(t, expected, obtained)
+	expected := "#line 1 " + quotedSketchLocation + "\nvoid setup();\n#line 2 " + quotedSketchLocation + "\nvoid loop();\n#line 4 " + quotedSketchLocation + "\nshort unsigned int testInt();\n#line 8 " + quotedSketchLocation + "\nstatic inline int8_t testInline();\n#line 12 " + quotedSketchLocation + "\n__attribute__((always_inline)) uint8_t testAttribute();\n#line 1 " + quotedSketchLocation + "\n"
+
+	t.Run("ctags", func(t *testing.T) {
+		ctx := newCtx()
+		runPrototypesAdder(t, ctx)
+
+		obtained := ctx.PrototypesSection
+		want := expected
+		// ctags based preprocessing removes "inline" but this is still OK
+		want = strings.Replace(want, "static inline int8_t testInline();", "static int8_t testInline();", -1)
+		obtained = strings.Replace(obtained, "static inline int8_t testInline();", "static int8_t testInline();", -1)
+		// ctags based preprocessing removes "__attribute__ ....." but this is still OK
+		want = strings.Replace(want, "__attribute__((always_inline)) uint8_t testAttribute();", "uint8_t testAttribute();", -1)
+		obtained = strings.Replace(obtained, "__attribute__((always_inline)) uint8_t testAttribute();", "uint8_t testAttribute();", -1)
+		require.Equal(t, want, obtained)
+	})
+
+	t.Run("clang", func(t *testing.T) {
+		ctx := newCtx()
+		ctx.PrototypeExtractor = prototypes.NewClangExtractor("")
+		runPrototypesAdder(t, ctx)
+
+		// unlike ctags, clang preserves "inline" and __attribute__((...))
+		// verbatim, so expected needs no adjustment here.
+		require.Equal(t, expected, ctx.PrototypesSection)
+	})
 }
 
 func TestPrototypesAdderSketchWithFunctionSignatureInsideIFDEF(t *testing.T) {
@@ -679,46 +740,69 @@ func TestPrototypesAdderSketchWithTypename(t *testing.T) {
 	sketchLocation := paths.New("sketch_with_typename", "sketch_with_typename.ino")
 	quotedSketchLocation := utils.QuoteCppPath(Abs(t, sketchLocation))
 
-	ctx := &types.Context{
-		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
-		BuiltInLibrariesDirs: paths.NewPathList("libraries", "downloaded_libraries"),
-		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
-		SketchLocation:       sketchLocation,
-		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
-		ArduinoAPIVersion:    "10600",
-		Verbose:              true,
+	newCtx := func() *types.Context {
+		return &types.Context{
+			HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+			BuiltInLibrariesDirs: paths.NewPathList("libraries", "downloaded_libraries"),
+			BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+			SketchLocation:       sketchLocation,
+			FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+			ArduinoAPIVersion:    "10600",
+			Verbose:              true,
+		}
 	}
+	runPrototypesAdder := func(t *testing.T, ctx *types.Context) {
+		buildPath := SetupBuildPath(t, ctx)
+		defer buildPath.RemoveAll()
 
-	buildPath := SetupBuildPath(t, ctx)
-	defer buildPath.RemoveAll()
+		commands := []types.Command{
 
-	commands := []types.Command{
+			&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
 
-		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+			&builder.ContainerMergeCopySketchFiles{},
 
-		&builder.ContainerMergeCopySketchFiles{},
+			&builder.ContainerFindIncludes{},
 
-		&builder.ContainerFindIncludes{},
+			&builder.PrintUsedLibrariesIfVerbose{},
+			&builder.WarnAboutArchIncompatibleLibraries{},
 
-		&builder.PrintUsedLibrariesIfVerbose{},
-		&builder.WarnAboutArchIncompatibleLibraries{},
+			&builder.ContainerAddPrototypes{},
+		}
 
-		&builder.ContainerAddPrototypes{},
-	}
+		for _, command := range commands {
+			err := command.Run(ctx)
+			NoError(t, err)
+		}
 
-	for _, command := range commands {
-		err := command.Run(ctx)
-		NoError(t, err)
+		require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
 	}
 
-	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
 	expected := "#line 6 " + quotedSketchLocation + "\nvoid setup();\n#line 10 " + quotedSketchLocation + "\nvoid loop();\n#line 12 " + quotedSketchLocation + "\ntypename Foo<char>::Bar func();\n#line 6 " + quotedSketchLocation + "\n"
-	obtained := ctx.PrototypesSection
-	// ctags based preprocessing ignores line with typename
-	// TODO: remove this exception when moving to a more powerful parser
-	expected = strings.Replace(expected, "#line 12 "+quotedSketchLocation+"\ntypename Foo<char>::Bar func();\n", "", -1)
-	obtained = strings.Replace(obtained, "#line 12 "+quotedSketchLocation+"\ntypename Foo<char>::Bar func();\n", "", -1)
-	require.Equal(t, expected, obtained)
+
+	t.Run("ctags", func(t *testing.T) {
+		ctx := newCtx()
+		runPrototypesAdder(t, ctx)
+
+		obtained := ctx.PrototypesSection
+		want := expected
+		// ctags based preprocessing ignores line with typename
+		want = strings.Replace(want, "#line 12 "+quotedSketchLocation+"\ntypename Foo<char>::Bar func();\n", "", -1)
+		obtained = strings.Replace(obtained, "#line 12 "+quotedSketchLocation+"\ntypename Foo<char>::Bar func();\n", "", -1)
+		require.Equal(t, want, obtained)
+	})
+
+	t.Run("clang", func(t *testing.T) {
+		ctx := newCtx()
+		// Exercises ctx.PrototypesParser, the per-build knob, rather than
+		// setting ctx.PrototypeExtractor directly as the other clang
+		// subtests do.
+		ctx.PrototypesParser = "clang"
+		runPrototypesAdder(t, ctx)
+
+		// unlike ctags, clang's AST sees the typename-qualified return
+		// type fine, so expected needs no adjustment here.
+		require.Equal(t, expected, ctx.PrototypesSection)
+	})
 }
 
 func TestPrototypesAdderSketchWithIfDef2(t *testing.T) {
@@ -854,6 +938,8 @@ func TestPrototypesAdderSketchWithConst(t *testing.T) {
 
 	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
 	require.Equal(t, "#line 1 "+quotedSketchLocation+"\nvoid setup();\n#line 2 "+quotedSketchLocation+"\nvoid loop();\n#line 4 "+quotedSketchLocation+"\nconst __FlashStringHelper* test();\n#line 6 "+quotedSketchLocation+"\nconst int test3();\n#line 8 "+quotedSketchLocation+"\nvolatile __FlashStringHelper* test2();\n#line 10 "+quotedSketchLocation+"\nvolatile int test4();\n#line 1 "+quotedSketchLocation+"\n", ctx.PrototypesSection)
+
+	assertSourceMapRoundTrips(t, ctx)
 }
 
 func TestPrototypesAdderSketchWithDosEol(t *testing.T) {
@@ -891,7 +977,8 @@ func TestPrototypesAdderSketchWithDosEol(t *testing.T) {
 		err := command.Run(ctx)
 		NoError(t, err)
 	}
-	// only requires no error as result
+
+	assertSourceMapRoundTrips(t, ctx)
 }
 
 func TestPrototypesAdderSketchWithSubstringFunctionMember(t *testing.T) {
@@ -934,3 +1021,270 @@ func TestPrototypesAdderSketchWithSubstringFunctionMember(t *testing.T) {
 
 	require.Contains(t, ctx.Source, "class Foo {\nint blooper(int x) { return x+1; }\n};\n\nFoo foo;\n\n#line 7 "+quotedSketchLocation+"\nvoid setup();")
 }
+
+// countingCTagsExtractor delegates to the real CTagsRunner but counts how
+// many times it was actually invoked, so tests can assert a cache hit
+// skipped it.
+type countingCTagsExtractor struct {
+	ctx   *types.Context
+	calls int
+}
+
+func (e *countingCTagsExtractor) Extract(ctx *types.Context, source string, mainFileName string) ([]*types.Prototype, int, error) {
+	e.calls++
+	if err := (&builder.CTagsRunner{}).Run(e.ctx); err != nil {
+		return nil, -1, err
+	}
+	return e.ctx.Prototypes, e.ctx.PrototypesLineWhereToInsert, nil
+}
+
+// Name implements prototypes.Extractor.
+func (e *countingCTagsExtractor) Name() string {
+	return "ctags"
+}
+
+func TestPrototypesAdderCachesAcrossRuns(t *testing.T) {
+	DownloadCoresAndToolsAndLibraries(t)
+
+	sketchLocation := paths.New("downloaded_libraries", "Bridge", "examples", "Bridge", "Bridge.ino")
+
+	ctx := &types.Context{
+		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+		OtherLibrariesDirs:   paths.NewPathList("libraries"),
+		SketchLocation:       sketchLocation,
+		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+		ArduinoAPIVersion:    "10600",
+		Verbose:              true,
+	}
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer buildPath.RemoveAll()
+
+	fake := &countingCTagsExtractor{ctx: ctx}
+	ctx.PrototypeExtractor = fake
+
+	commands := []types.Command{
+		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+		&builder.ContainerMergeCopySketchFiles{},
+		&builder.ContainerFindIncludes{},
+		&builder.PrintUsedLibrariesIfVerbose{},
+		&builder.WarnAboutArchIncompatibleLibraries{},
+	}
+	for _, command := range commands {
+		NoError(t, command.Run(ctx))
+	}
+
+	NoError(t, (&builder.ContainerAddPrototypes{}).Run(ctx))
+	require.Equal(t, 1, fake.calls)
+
+	NoError(t, (&builder.ContainerAddPrototypes{}).Run(ctx))
+	require.Equal(t, 1, fake.calls, "second run on an unchanged sketch should hit prototypes.cache.json and skip ctags")
+}
+
+func TestPrototypesAdderCacheInvalidatesOnSecondaryTabWhitespaceChange(t *testing.T) {
+	DownloadCoresAndToolsAndLibraries(t)
+
+	sketchLocation := paths.New("sketch_no_functions_two_files", "sketch_no_functions_two_files.ino")
+	secondaryTab := paths.New("sketch_no_functions_two_files", "old.pde")
+
+	ctx := &types.Context{
+		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+		OtherLibrariesDirs:   paths.NewPathList("libraries"),
+		SketchLocation:       sketchLocation,
+		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+		ArduinoAPIVersion:    "10600",
+		Verbose:              true,
+	}
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer buildPath.RemoveAll()
+
+	fake := &countingCTagsExtractor{ctx: ctx}
+	ctx.PrototypeExtractor = fake
+
+	run := func() {
+		commands := []types.Command{
+			&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+			&builder.ContainerMergeCopySketchFiles{},
+			&builder.ContainerFindIncludes{},
+			&builder.PrintUsedLibrariesIfVerbose{},
+			&builder.WarnAboutArchIncompatibleLibraries{},
+		}
+		for _, command := range commands {
+			NoError(t, command.Run(ctx))
+		}
+		NoError(t, (&builder.ContainerAddPrototypes{}).Run(ctx))
+	}
+
+	run()
+	require.Equal(t, 1, fake.calls)
+
+	// The merged source is every tab concatenated together, so whitespace
+	// added to a secondary tab still changes its hash even though nothing
+	// in the main sketch file moved.
+	original, err := secondaryTab.ReadFile()
+	NoError(t, err)
+	NoError(t, secondaryTab.WriteFile(append(original, '\n')))
+	defer secondaryTab.WriteFile(original)
+
+	run()
+	require.Equal(t, 2, fake.calls, "whitespace added to a secondary sketch tab should change the merged source hash and invalidate prototypes.cache.json")
+}
+
+// namedCountingExtractor is like countingCTagsExtractor but reports a
+// caller-chosen backend Name(), so a test can flip it between runs without
+// touching the sketch.
+type namedCountingExtractor struct {
+	countingCTagsExtractor
+	name string
+}
+
+func (e *namedCountingExtractor) Name() string {
+	return e.name
+}
+
+func TestPrototypesAdderCacheInvalidatesOnBackendChange(t *testing.T) {
+	DownloadCoresAndToolsAndLibraries(t)
+
+	sketchLocation := paths.New("downloaded_libraries", "Bridge", "examples", "Bridge", "Bridge.ino")
+
+	ctx := &types.Context{
+		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+		OtherLibrariesDirs:   paths.NewPathList("libraries"),
+		SketchLocation:       sketchLocation,
+		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+		ArduinoAPIVersion:    "10600",
+		Verbose:              true,
+	}
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer buildPath.RemoveAll()
+
+	fake := &namedCountingExtractor{countingCTagsExtractor: countingCTagsExtractor{ctx: ctx}, name: "ctags"}
+	ctx.PrototypeExtractor = fake
+
+	run := func() {
+		commands := []types.Command{
+			&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+			&builder.ContainerMergeCopySketchFiles{},
+			&builder.ContainerFindIncludes{},
+			&builder.PrintUsedLibrariesIfVerbose{},
+			&builder.WarnAboutArchIncompatibleLibraries{},
+		}
+		for _, command := range commands {
+			NoError(t, command.Run(ctx))
+		}
+		NoError(t, (&builder.ContainerAddPrototypes{}).Run(ctx))
+	}
+
+	run()
+	require.Equal(t, 1, fake.calls)
+
+	run()
+	require.Equal(t, 1, fake.calls, "second run with the same backend and an unchanged sketch should hit the cache")
+
+	fake.name = "treesitter"
+	run()
+	require.Equal(t, 2, fake.calls, "switching backends on an unchanged sketch must miss the cache instead of serving the ctags-generated entry")
+}
+
+func TestPrototypesAdderSketchWithTrailingReturn(t *testing.T) {
+	DownloadCoresAndToolsAndLibraries(t)
+
+	sketchLocation := paths.New("sketch_with_trailing_return", "sketch_with_trailing_return.ino")
+	quotedSketchLocation := utils.QuoteCppPath(Abs(t, sketchLocation))
+
+	ctx := &types.Context{
+		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+		OtherLibrariesDirs:   paths.NewPathList("libraries"),
+		SketchLocation:       sketchLocation,
+		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+		ArduinoAPIVersion:    "10600",
+		Verbose:              true,
+	}
+	ctx.PrototypeExtractor = prototypes.NewTreeSitterExtractor()
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer buildPath.RemoveAll()
+
+	commands := []types.Command{
+
+		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+
+		&builder.ContainerMergeCopySketchFiles{},
+
+		&builder.ContainerFindIncludes{},
+
+		&builder.PrintUsedLibrariesIfVerbose{},
+		&builder.WarnAboutArchIncompatibleLibraries{},
+
+		&builder.ContainerAddPrototypes{},
+	}
+
+	for _, command := range commands {
+		err := command.Run(ctx)
+		NoError(t, err)
+	}
+
+	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
+	// sum() keeps its "-> int" trailing return type verbatim; guess(), whose
+	// "auto" return type is deduced rather than trailing, can't be
+	// forward-declared and is left out of the prototypes section entirely.
+	require.Equal(t, "#line 1 "+quotedSketchLocation+"\nvoid setup();\n#line 3 "+quotedSketchLocation+"\nvoid loop();\n#line 6 "+quotedSketchLocation+"\nauto sum(int a, int b) -> int;\n#line 1 "+quotedSketchLocation+"\n", ctx.PrototypesSection)
+}
+
+func TestPrototypesAdderSketchWithDefaultTemplateArgs(t *testing.T) {
+	DownloadCoresAndToolsAndLibraries(t)
+
+	sketchLocation := paths.New("sketch_with_default_template_args", "sketch_with_default_template_args.ino")
+	quotedSketchLocation := utils.QuoteCppPath(Abs(t, sketchLocation))
+
+	ctx := &types.Context{
+		HardwareDirs:         paths.NewPathList(filepath.Join("..", "hardware"), "downloaded_hardware"),
+		BuiltInToolsDirs:     paths.NewPathList("downloaded_tools"),
+		BuiltInLibrariesDirs: paths.NewPathList("downloaded_libraries"),
+		OtherLibrariesDirs:   paths.NewPathList("libraries"),
+		SketchLocation:       sketchLocation,
+		FQBN:                 parseFQBN(t, "arduino:avr:leonardo"),
+		ArduinoAPIVersion:    "10600",
+		Verbose:              true,
+	}
+	ctx.PrototypeExtractor = prototypes.NewTreeSitterExtractor()
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer buildPath.RemoveAll()
+
+	commands := []types.Command{
+
+		&builder.ContainerSetupHardwareToolsLibsSketchAndProps{},
+
+		&builder.ContainerMergeCopySketchFiles{},
+
+		&builder.ContainerFindIncludes{},
+
+		&builder.PrintUsedLibrariesIfVerbose{},
+		&builder.WarnAboutArchIncompatibleLibraries{},
+
+		&builder.ContainerAddPrototypes{},
+	}
+
+	for _, command := range commands {
+		err := command.Run(ctx)
+		NoError(t, err)
+	}
+
+	require.Contains(t, ctx.Source, "#include <Arduino.h>\n#line 1 "+quotedSketchLocation+"\n")
+	// f()'s default argument has both angle brackets and a brace pair of its
+	// own; since the prototype text is sliced straight out of the AST
+	// node's byte range rather than hand-scanned, it comes through whole
+	// instead of being cut off at the first ">" or "}".
+	require.Equal(t, "#line 1 "+quotedSketchLocation+"\nvoid setup();\n#line 3 "+quotedSketchLocation+"\nvoid loop();\n#line 6 "+quotedSketchLocation+"\nvoid f(std::map<int, int> m = {});\n#line 1 "+quotedSketchLocation+"\n", ctx.PrototypesSection)
+}
@@ -25,6 +25,8 @@ import (
 	"github.com/arduino/arduino-cli/arduino/libraries"
 	"github.com/arduino/arduino-cli/arduino/libraries/librariesmanager"
 	"github.com/arduino/arduino-cli/arduino/libraries/librariesresolver"
+	"github.com/arduino/arduino-cli/legacy/builder/diagnostics"
+	"github.com/arduino/arduino-cli/legacy/builder/executor"
 	"github.com/arduino/arduino-cli/legacy/builder/i18n"
 	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
 	paths "github.com/arduino/go-paths-helper"
@@ -107,6 +109,11 @@ type Context struct {
 	SketchObjectFiles            paths.PathList
 	IgnoreSketchFolderNameErrors bool
 
+	// Maximum size (in megabytes) of the shared object-file cache kept
+	// under BuildCachePath. A value of 0 disables pruning (the cache is
+	// allowed to grow without bound).
+	CompileCacheSizeMB int
+
 	CollectedSourceFiles *UniqueSourceFileQueue
 
 	Sketch          *Sketch
@@ -132,7 +139,17 @@ type Context struct {
 	PrototypesSection           string
 	PrototypesLineWhereToInsert int
 	Prototypes                  []*Prototype
-	
+
+	// SourceMap maps lines of the preprocessed, prototype-spliced ctx.Source
+	// back to the original .ino/.cpp file and line they came from. It is
+	// built by ContainerAddPrototypes from the "#line N \"path\"" markers
+	// FilterSketchSource and the prototype-insertion step leave in
+	// ctx.Source, sorted by MergedLineStart. Tools that read ctx.Source
+	// directly (linters, static analyzers) rather than relying on the
+	// compiler's own #line handling can use Lookup to translate offsets
+	// back to locations the user recognizes.
+	SourceMap []SourceMapEntry
+
 	CodeModelBuilder     *CodeModelBuilder
 	CodeModelBuilderFile string
 	UnoptimizeSketch     bool
@@ -177,11 +194,180 @@ type Context struct {
 	CompilationDatabase *builder.CompilationDatabase
 	// Set to true to skip build and produce only Compilation Database
 	OnlyUpdateCompilationDatabase bool
+	// If non-nil, ExportCompileCommands writes CompilationDatabase out as a
+	// clangd/CDT-style compile_commands.json in this directory (normally
+	// BuildPath) once the build has finished populating it. Set from the
+	// compile command's --export-compile-commands flag.
+	ExportCompileCommandsPath *paths.Path
 
 	// Source code overrides (filename -> content map).
 	// The provided source data is used instead of reading it from disk.
 	// The keys of the map are paths relative to sketch folder.
 	SourceOverride map[string]string
+
+	// DiagnosticSink, when non-nil, receives a Diagnostic for every
+	// compiler message parsed out of a compile/link recipe's stderr.
+	// When nil, the current Show/Capture textual behavior is used
+	// instead.
+	DiagnosticSink chan diagnostics.Diagnostic
+
+	// OutputFormat mirrors the top-level --format flag ("text" or
+	// "json") down into the builder, so utils.ExecCommand can decide
+	// whether a compile/link recipe's diagnostics should be re-emitted as
+	// structured JSON on ExecStdout instead of colorized/relativized
+	// plain text. Left empty, it behaves like "text".
+	OutputFormat string
+
+	// Executor runs every recipe command line. When nil, utils.ExecCommand
+	// falls back to its built-in local os/exec runner. Set this to an
+	// executor.ContainerExecutor or executor.RemoteExecutor to build
+	// without a locally installed toolchain.
+	Executor executor.Executor
+
+	// Reproducible enables hermetic build mode: absolute build paths are
+	// remapped to a fixed prefix and SOURCE_DATE_EPOCH is exported to the
+	// toolchain, so two builds of the same sketch/FQBN from different
+	// machines or directories produce byte-identical output.
+	Reproducible bool
+	// SourceDateEpoch is the Unix timestamp embedded in the build when
+	// Reproducible is set. Zero means "derive it from the sketch's main
+	// file mtime", matching the SOURCE_DATE_EPOCH convention.
+	SourceDateEpoch int64
+
+	// PrototypeExtractor, when set, replaces the ctags-based CTagsRunner
+	// with a real C++ frontend: tree-sitter or clang (see package
+	// prototypes, and its "builder.prototypes_extractor" setting);
+	// PrototypesAdder still splices in what it extracts. Left nil,
+	// ContainerAddPrototypes resolves one from PrototypesParser or that
+	// setting, and the legacy ctags pipeline is used when that yields none;
+	// if a non-nil extractor fails to parse, PrototypeExtractorRunner falls
+	// back to it too.
+	PrototypeExtractor PrototypeExtractor
+
+	// PrototypesParser selects the extractor ContainerAddPrototypes falls
+	// back to building when PrototypeExtractor is still nil: "ctags" leaves
+	// the legacy pipeline in charge, "clang" drives
+	// prototypes.NewClangExtractor. When non-empty it takes precedence over
+	// the "builder.prototypes_extractor" configuration setting, letting a
+	// single compile request force the parser without touching global
+	// configuration; left empty, that setting decides.
+	PrototypesParser string
+
+	// DisablePrototypeCache makes ContainerAddPrototypes always rerun
+	// prototype extraction, ignoring and never updating
+	// preproc/prototypes.cache.json. Set from the compile command's
+	// --no-prototype-cache flag.
+	DisablePrototypeCache bool
+
+	// IncludeResolver, when set, replaces the gcc-preprocessor-plus-regexp
+	// approach to include detection (see ContainerFindIncludes) with a
+	// real C++ frontend (see package includes). Left nil, the legacy gcc
+	// pipeline is used.
+	IncludeResolver IncludeResolver
+
+	// BuildCacheMode selects how a source file's up-to-date checks are
+	// decided: both ContainerFindIncludes' cached include-detection
+	// journal and builder_utils' skip-if-unchanged check ahead of
+	// invoking the compiler consult it. The zero value behaves like
+	// BuildCacheModeMTime.
+	BuildCacheMode BuildCacheMode
+
+	// DumpIncludeGraph makes ContainerFindIncludes write include-graph.json
+	// under BuildPath: a machine-readable record of, per source file, the
+	// include chain walked and the library (if any) that satisfied each
+	// #include, including alternatives ResolveLibrary rejected. Meant for
+	// external tooling (build visualizers, dependency auditors) rather than
+	// arduino-cli itself, so it costs nothing when left false.
+	DumpIncludeGraph bool
+
+	// UpdateLock makes CoreBuilder (and its sibling library/sketch builders)
+	// refresh the sketch's arduino-lock.yaml to the platform, tools, cores,
+	// libraries and FQBN currently resolved, instead of checking the build
+	// against whatever was previously locked there. Nothing sets this yet:
+	// it needs a `--update-lock` flag on the `compile` command, which isn't
+	// wired up (see checkLockfile's doc comment).
+	UpdateLock bool
+}
+
+// SourceMapEntry records that merged lines [MergedLineStart, MergedLineEnd]
+// of ctx.Source came from OriginalFile starting at OriginalLine. Reason
+// records why the run exists ("sketch" for the user's own code, "prototype"
+// for a declaration ContainerAddPrototypes spliced in).
+type SourceMapEntry struct {
+	MergedLineStart int         `json:"merged_line_start"`
+	MergedLineEnd   int         `json:"merged_line_end"`
+	OriginalFile    *paths.Path `json:"original_file"`
+	OriginalLine    int         `json:"original_line"`
+	Reason          string      `json:"reason"`
+}
+
+// Lookup translates a line number in ctx.Source back to the original file
+// and line it was generated from, using ctx.SourceMap. It returns a nil
+// path if line predates any recorded mapping.
+func (ctx *Context) Lookup(line int) (*paths.Path, int) {
+	var best *SourceMapEntry
+	for i := range ctx.SourceMap {
+		entry := &ctx.SourceMap[i]
+		if entry.MergedLineStart > line {
+			break
+		}
+		best = entry
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best.OriginalFile, best.OriginalLine + (line - best.MergedLineStart)
+}
+
+// BuildCacheMode is the "build.cache.mode" configuration setting.
+type BuildCacheMode string
+
+const (
+	// BuildCacheModeMTime trusts a source file's cached entries based on
+	// ObjFileIsUpToDate's timestamp comparison against the object and
+	// dependency files, exactly as arduino-cli has always done. This is
+	// fast, but unreliable across git checkouts, `touch`, filesystem
+	// copies or CI cache restores that don't preserve relative mtimes.
+	BuildCacheModeMTime BuildCacheMode = "mtime"
+	// BuildCacheModeHash ignores timestamps entirely and instead hashes
+	// the source file and every file listed in its dependency file,
+	// trusting the cache iff every recorded hash still matches.
+	BuildCacheModeHash BuildCacheMode = "hash"
+	// BuildCacheModeHybrid only falls back to hashing when the mtime
+	// check says a file is stale, keeping the fast path for the common
+	// case where timestamps are trustworthy.
+	BuildCacheModeHybrid BuildCacheMode = "hybrid"
+)
+
+// PrototypeExtractor parses preprocessed sketch source and returns the
+// prototypes that must be inserted, along with the line number to insert
+// them at (or -1 if no insertion point was found, matching
+// ctags.CTagsParser's convention). ctx is passed through mainly so an
+// implementation can log via ctx.GetLogger() (e.g. to report a function it
+// deliberately left out, such as one with a deduced "auto" return type).
+// Implementations live in package prototypes; the interface is declared
+// here (rather than there) to avoid an import cycle, since its
+// implementations need to construct *Prototype values.
+type PrototypeExtractor interface {
+	Extract(ctx *Context, source string, mainFileName string) (prototypes []*Prototype, insertLine int, err error)
+
+	// Name identifies the backend (e.g. "treesitter", "clang"), matching
+	// the "builder.prototypes_extractor"/ctx.PrototypesParser value that
+	// selects it. Used to key the prototypes cache so switching backends
+	// on an otherwise-unchanged sketch can't serve a stale entry produced
+	// by a different one.
+	Name() string
+}
+
+// IncludeResolver finds the first #include in sourcePath that cannot be
+// resolved against includes, returning "" if everything resolves. err is
+// returned only when the underlying tool itself failed to run or its
+// output couldn't be parsed; an unresolved #include is reported through
+// the returned include name, not err. Implementations live in package
+// includes; the interface is declared here (rather than there) to avoid
+// an import cycle with the legacy/builder package that calls it.
+type IncludeResolver interface {
+	FindMissingInclude(sourcePath *paths.Path, includes paths.PathList) (include string, err error)
 }
 
 // ExecutableSectionSize represents a section of the executable output file
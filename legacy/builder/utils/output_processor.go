@@ -0,0 +1,184 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arduino/arduino-cli/legacy/builder/diagnostics"
+	"github.com/arduino/arduino-cli/legacy/builder/types"
+	paths "github.com/arduino/go-paths-helper"
+)
+
+// OutputProcessor rewrites a recipe's stderr for display as ExecCommand
+// streams it, one line at a time - e.g. colorizing and relativizing it for
+// a terminal, or re-emitting each recognized diagnostic as a line of
+// structured JSON. It only shapes what the user sees: ctx.DiagnosticSink is
+// always fed from the complete, unprocessed stderr, regardless of which
+// OutputProcessor (if any) is active.
+type OutputProcessor interface {
+	// ProcessLine returns the text to display in line's place, and
+	// whether the line should be displayed at all.
+	ProcessLine(line string) (output string, keep bool)
+}
+
+// NewOutputProcessor picks the OutputProcessor ExecCommand should apply to
+// dest, or nil if output shouldn't be rewritten: a JSONOutputProcessor when
+// ctx.OutputFormat is "json", a ColorOutputProcessor when dest is a
+// terminal, and nothing (the classic raw passthrough) otherwise.
+func NewOutputProcessor(ctx *types.Context, dest io.Writer) OutputProcessor {
+	switch {
+	case ctx.OutputFormat == "json":
+		return &JSONOutputProcessor{}
+	case isTerminal(dest):
+		return &ColorOutputProcessor{WorkingDir: ctx.BuildPath}
+	default:
+		return nil
+	}
+}
+
+// JSONOutputProcessor re-emits every diagnostic ParseClassic recognizes in
+// a line as a single line of JSON shaped like diagnostics.Diagnostic
+// ({file, line, column, severity, message, category}). Lines it doesn't
+// recognize (compiler banners, "In file included from", ...) are dropped,
+// so a frontend reading stderr under --format=json can treat every line it
+// receives as one parseable record.
+type JSONOutputProcessor struct{}
+
+// ProcessLine implements OutputProcessor.
+func (p *JSONOutputProcessor) ProcessLine(line string) (string, bool) {
+	diags := diagnostics.ParseClassic(line)
+	if len(diags) == 0 {
+		return "", false
+	}
+	encoded, err := json.Marshal(diags[0])
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// severityColor are the ANSI escapes ColorOutputProcessor uses for each
+// diagnostic severity; colorReset restores the terminal's default.
+var severityColor = map[string]string{
+	"error":   "\x1b[31;1m",
+	"warning": "\x1b[33;1m",
+	"note":    "\x1b[36m",
+}
+
+const colorReset = "\x1b[0m"
+
+// ColorOutputProcessor colorizes a recognized diagnostic line's severity
+// keyword and, when WorkingDir is set, relativizes its file path to it.
+// Lines ParseClassic doesn't recognize pass through unchanged.
+type ColorOutputProcessor struct {
+	WorkingDir *paths.Path
+}
+
+// ProcessLine implements OutputProcessor.
+func (p *ColorOutputProcessor) ProcessLine(line string) (string, bool) {
+	diags := diagnostics.ParseClassic(line)
+	if len(diags) == 0 {
+		return line, true
+	}
+	d := diags[0]
+
+	file := d.File
+	if p.WorkingDir != nil {
+		if rel, err := p.WorkingDir.RelTo(paths.New(d.File)); err == nil {
+			file = rel.String()
+		}
+	}
+
+	category := ""
+	if d.Category != "" {
+		category = " [" + d.Category + "]"
+	}
+
+	color := severityColor[d.Severity]
+	return fmt.Sprintf("%s:%d:%d: %s%s%s: %s%s", file, d.Line, d.Column, color, d.Severity, colorReset, d.Message, category), true
+}
+
+// isTerminal reports whether w is a character device (a terminal) rather
+// than a file, pipe or in-memory buffer, so ColorOutputProcessor is only
+// picked when there's actually someone at a screen to show color to.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// lineFilterWriter buffers partial writes and forwards each complete line
+// through an OutputProcessor before writing it (plus its newline) to dest.
+// Call Flush once the writer won't be written to again, so a final,
+// newline-less partial line isn't lost.
+type lineFilterWriter struct {
+	dest io.Writer
+	proc OutputProcessor
+	buf  bytes.Buffer
+}
+
+func newLineFilterWriter(dest io.Writer, proc OutputProcessor) *lineFilterWriter {
+	return &lineFilterWriter{dest: dest, proc: proc}
+}
+
+// Write implements io.Writer.
+func (w *lineFilterWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		data := w.buf.Bytes()
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(data[:i])
+		w.buf.Next(i + 1)
+		if err := w.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *lineFilterWriter) writeLine(line string) error {
+	out, keep := w.proc.ProcessLine(line)
+	if !keep {
+		return nil
+	}
+	_, err := fmt.Fprintln(w.dest, out)
+	return err
+}
+
+// Flush writes out any trailing, newline-less line left over from the last
+// Write call.
+func (w *lineFilterWriter) Flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := w.buf.String()
+	w.buf.Reset()
+	return w.writeLine(line)
+}
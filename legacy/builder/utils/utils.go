@@ -29,6 +29,8 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/arduino/arduino-cli/legacy/builder/diagnostics"
+	"github.com/arduino/arduino-cli/legacy/builder/executor"
 	"github.com/arduino/arduino-cli/legacy/builder/gohasissues"
 	"github.com/arduino/arduino-cli/legacy/builder/types"
 	paths "github.com/arduino/go-paths-helper"
@@ -183,6 +185,10 @@ func ExecCommand(ctx *types.Context, command *exec.Cmd, stdout int, stderr int)
 		ctx.GetLogger().UnformattedFprintln(os.Stdout, PrintableCommand(command.Args))
 	}
 
+	if ctx.Executor != nil {
+		return execWithExecutor(ctx, command, stdout, stderr)
+	}
+
 	if stdout == Capture {
 		buffer := &bytes.Buffer{}
 		command.Stdout = buffer
@@ -190,11 +196,40 @@ func ExecCommand(ctx *types.Context, command *exec.Cmd, stdout int, stderr int)
 		command.Stdout = ctx.ExecStdout
 	}
 
+	// When a diagnostics sink is attached, stderr is always captured (in
+	// addition to whatever the caller asked for) so compiler messages can
+	// be parsed into structured Diagnostic records.
+	var diagnosticsBuffer *bytes.Buffer
+	if ctx.DiagnosticSink != nil {
+		diagnosticsBuffer = &bytes.Buffer{}
+	}
+
+	// When an OutputProcessor applies (structured JSON re-emission, or
+	// colorizing/relativizing for a terminal), what's actually shown is
+	// rewritten line-by-line as it streams by; diagnosticsBuffer still
+	// always collects the raw, unprocessed bytes, so ctx.DiagnosticSink
+	// is unaffected by whichever OutputProcessor (if any) is active.
+	var stderrFilter *lineFilterWriter
+	displayStderr := ctx.ExecStderr
+	if proc := NewOutputProcessor(ctx, ctx.ExecStderr); proc != nil {
+		stderrFilter = newLineFilterWriter(ctx.ExecStderr, proc)
+		displayStderr = stderrFilter
+	}
+
 	if stderr == Capture {
-		buffer := &bytes.Buffer{}
-		command.Stderr = buffer
+		if diagnosticsBuffer != nil {
+			command.Stderr = diagnosticsBuffer
+		} else {
+			command.Stderr = &bytes.Buffer{}
+		}
 	} else if stderr == Show || stderr == ShowIfVerbose && ctx.Verbose {
-		command.Stderr = ctx.ExecStderr
+		if diagnosticsBuffer != nil {
+			command.Stderr = io.MultiWriter(displayStderr, diagnosticsBuffer)
+		} else {
+			command.Stderr = displayStderr
+		}
+	} else if diagnosticsBuffer != nil {
+		command.Stderr = diagnosticsBuffer
 	}
 
 	err := command.Start()
@@ -204,6 +239,16 @@ func ExecCommand(ctx *types.Context, command *exec.Cmd, stdout int, stderr int)
 
 	err = command.Wait()
 
+	if stderrFilter != nil {
+		stderrFilter.Flush()
+	}
+
+	if diagnosticsBuffer != nil {
+		for _, d := range diagnostics.Parse(diagnosticsBuffer.Bytes()) {
+			ctx.DiagnosticSink <- d
+		}
+	}
+
 	var outbytes, errbytes []byte
 	if buf, ok := command.Stdout.(*bytes.Buffer); ok {
 		outbytes = buf.Bytes()
@@ -215,6 +260,35 @@ func ExecCommand(ctx *types.Context, command *exec.Cmd, stdout int, stderr int)
 	return outbytes, errbytes, errors.WithStack(err)
 }
 
+// execWithExecutor runs command through ctx.Executor instead of directly
+// via os/exec, e.g. to build inside a container or dispatch to a remote
+// build farm. It preserves ExecCommand's Show/Capture semantics for the
+// output streams, as well as diagnostics parsing.
+func execWithExecutor(ctx *types.Context, command *exec.Cmd, stdout int, stderr int) ([]byte, []byte, error) {
+	spec := executor.Spec{Path: command.Path, Args: command.Args[1:], Dir: command.Dir}
+	outbytes, errbytes, err := ctx.Executor.Run(spec)
+
+	if stdout == Show || stdout == ShowIfVerbose && ctx.Verbose {
+		ctx.ExecStdout.Write(outbytes)
+	}
+	if stderr == Show || stderr == ShowIfVerbose && ctx.Verbose {
+		if proc := NewOutputProcessor(ctx, ctx.ExecStderr); proc != nil {
+			filter := newLineFilterWriter(ctx.ExecStderr, proc)
+			filter.Write(errbytes)
+			filter.Flush()
+		} else {
+			ctx.ExecStderr.Write(errbytes)
+		}
+	}
+	if ctx.DiagnosticSink != nil {
+		for _, d := range diagnostics.Parse(errbytes) {
+			ctx.DiagnosticSink <- d
+		}
+	}
+
+	return outbytes, errbytes, errors.WithStack(err)
+}
+
 func AbsolutizePaths(files []string) ([]string, error) {
 	for idx, file := range files {
 		if file == "" {
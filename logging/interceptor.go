@@ -0,0 +1,121 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package logging
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// sketchPathGetter, fqbnGetter and portGetter are satisfied by the request
+// messages (CompileRequest, UploadRequest, BoardListRequest, ...) that carry
+// the field of the same name - used on a best-effort basis to enrich an
+// RPC's log entry without every caller having to do it by hand.
+type sketchPathGetter interface{ GetSketchPath() string }
+type fqbnGetter interface{ GetFqbn() string }
+type portGetter interface{ GetPort() string }
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that, for
+// every unary RPC, creates a logrus.Entry carrying command, subcommand,
+// request_id and - when the request exposes them - sketch/fqbn/port,
+// threads it through the handler's context.Context (retrievable with
+// FromContext), and logs the RPC's outcome and duration_ms once it
+// returns. It is the arduino-cli daemon's analogue of ginrus for gin.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		entry, ctx := newRPCEntry(ctx, info.FullMethod, req)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPCResult(entry, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor, for the monitor service's bidirectional streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		entry, ctx := newRPCEntry(ss.Context(), info.FullMethod, nil)
+		start := time.Now()
+		err := handler(srv, &loggingServerStream{ServerStream: ss, ctx: ctx})
+		logRPCResult(entry, start, err)
+		return err
+	}
+}
+
+// loggingServerStream overrides ServerStream.Context so handlers observe the
+// context StreamServerInterceptor attached the per-RPC entry to.
+type loggingServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// newRPCEntry builds the per-RPC logrus.Entry and returns ctx carrying it.
+func newRPCEntry(ctx context.Context, fullMethod string, req interface{}) (*logrus.Entry, context.Context) {
+	service, method := splitFullMethod(fullMethod)
+	fields := logrus.Fields{
+		"command":      "daemon",
+		"subcommand":   method,
+		"grpc_service": service,
+		"request_id":   uuid.New().String(),
+	}
+	if sketch, ok := req.(sketchPathGetter); ok {
+		fields["sketch"] = sketch.GetSketchPath()
+	}
+	if fqbn, ok := req.(fqbnGetter); ok {
+		fields["fqbn"] = fqbn.GetFqbn()
+	}
+	if port, ok := req.(portGetter); ok {
+		fields["port"] = port.GetPort()
+	}
+
+	entry := logrus.WithFields(fields)
+	return entry, NewContext(ctx, entry)
+}
+
+// splitFullMethod splits a gRPC FullMethod ("/package.Service/Method") into
+// its service and method parts.
+func splitFullMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	parts := strings.SplitN(fullMethod, "/", 2)
+	if len(parts) != 2 {
+		return "", fullMethod
+	}
+	return parts[0], parts[1]
+}
+
+// logRPCResult logs entry's RPC outcome, adding duration_ms and exit_code.
+func logRPCResult(entry *logrus.Entry, start time.Time, err error) {
+	entry = entry.WithFields(logrus.Fields{
+		"duration_ms": time.Since(start).Milliseconds(),
+		"exit_code":   status.Code(err),
+	})
+	if err != nil {
+		entry.WithError(err).Warn("RPC failed")
+		return
+	}
+	entry.Info("RPC completed")
+}
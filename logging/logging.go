@@ -0,0 +1,99 @@
+// This file is part of arduino-cli.
+//
+// Copyright 2020 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Package logging provides the structured-logging building blocks shared by
+// the CLI and the gRPC daemon: a logging.schema-driven formatter emitting a
+// fixed set of fields ELK/Loki can ingest without post-processing, and a
+// per-RPC logrus.Entry threaded through context.Context so handlers can add
+// their own fields with logging.FromContext(ctx).WithField(...) instead of
+// reaching for the global logger.
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The supported logging.schema settings.
+const (
+	SchemaPlain    = "plain"
+	SchemaLogstash = "logstash"
+	SchemaECS      = "ecs"
+)
+
+// NewFormatter returns the logrus.Formatter for the given logging.schema
+// setting. "plain" (the default, including an empty string) keeps the
+// pre-existing plain JSON formatting; "logstash" and "ecs" rename the
+// default fields to @timestamp/message/level so the result matches what
+// Logstash and the Elastic Common Schema expect out of the box. The fixed
+// @version/service.name/service.version fields both schemas also require
+// are added by ServiceFieldsHook, not by the formatter, since a Formatter
+// only renders entry.Data - it can't add fields to it.
+func NewFormatter(schema string) logrus.Formatter {
+	switch schema {
+	case SchemaLogstash, SchemaECS:
+		return &logrus.JSONFormatter{
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime:  "@timestamp",
+				logrus.FieldKeyMsg:   "message",
+				logrus.FieldKeyLevel: "level",
+			},
+		}
+	default:
+		return &logrus.JSONFormatter{}
+	}
+}
+
+// ServiceFieldsHook stamps every log entry with the fixed service.* fields
+// the "logstash" and "ecs" formatters expect, on top of whatever contextual
+// fields (command, sketch, fqbn, request_id, ...) were already added via
+// FromContext(ctx).WithField.
+type ServiceFieldsHook struct {
+	ServiceVersion string
+}
+
+// Levels implements logrus.Hook.
+func (h ServiceFieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (h ServiceFieldsHook) Fire(entry *logrus.Entry) error {
+	entry.Data["@version"] = "1"
+	entry.Data["service.name"] = "arduino-cli"
+	entry.Data["service.version"] = h.ServiceVersion
+	return nil
+}
+
+// entryKey is the context.Context key FromContext/NewContext store the
+// per-RPC logrus.Entry under.
+type entryKey struct{}
+
+// NewContext returns a copy of ctx carrying entry, retrievable with
+// FromContext.
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext returns the logrus.Entry a gRPC interceptor attached to ctx,
+// or a plain entry on the standard logger if ctx carries none (e.g. outside
+// the daemon, or in tests that don't wire one up).
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}